@@ -0,0 +1,461 @@
+package filemgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+)
+
+var pngSig = []byte("\x89PNG\r\n\x1a\n")
+
+const (
+	maxFrames     = 1000    // frames of animation
+	maxAnimPixels = 1 << 27 // pixels of all frames composited on canvas, 512MB as RGBA
+)
+
+// animation of 'frames' on canvas 'w' x 'h' fits limits before frames are composited
+func checkAnimation(w, h, frames int) error {
+	if frames > maxFrames || w < 0 || h < 0 || w > maxAnimPixels || h > maxAnimPixels ||
+		int64(w)*int64(h) > maxAnimPixels/int64(max(frames, 1)) {
+		return fmt.Errorf("animation of %d frames in %dx%d exceeds %d frames or %d pixels", frames, w, h, maxFrames, maxAnimPixels)
+	}
+	return nil
+}
+
+// animation is frames of animated GIF or APNG, each composited on full canvas
+type animation struct {
+	format   string // "gif" or "png"
+	frames   []*image.RGBA
+	delays   []time.Duration
+	plays    int             // 0 plays forever
+	palettes []color.Palette // of GIF frames, reused for encoding GIF
+}
+
+func (a *animation) duration() (d time.Duration) {
+	for _, delay := range a.delays {
+		d += delay
+	}
+	return d
+}
+
+// apply 'fn' to every frame, frames MUST keep same size
+func (a *animation) transform(fn func(img image.Image) (image.Image, error)) error {
+	for i, frame := range a.frames {
+		img, err := fn(frame)
+		if err != nil {
+			return err
+		}
+		b := img.Bounds()
+		a.frames[i] = roi4rgba(img, b.Min.X, b.Min.Y, b.Max.X, b.Max.Y)
+	}
+	return nil
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	return &image.RGBA{Pix: append([]uint8{}, img.Pix...), Stride: img.Stride, Rect: img.Rect}
+}
+
+// animation of GIF or APNG file, nil if it is NOT animated
+func loadAnimation(fPath string) (*animation, error) {
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+	var a *animation
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		a, err = decodeGIF(bytes.NewReader(data))
+	case bytes.HasPrefix(data, pngSig) && pngChunk(data, "acTL") != nil:
+		a, err = decodeAPNG(data)
+	}
+	if err != nil || a == nil || len(a.frames) < 2 {
+		return nil, err
+	}
+	return a, nil
+}
+
+func decodeGIF(r io.Reader) (*animation, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+	a := &animation{format: "gif"}
+	switch {
+	case g.LoopCount == 0:
+	case g.LoopCount < 0:
+		a.plays = 1
+	default:
+		a.plays = g.LoopCount + 1
+	}
+	if err := checkAnimation(g.Config.Width, g.Config.Height, len(g.Image)); err != nil {
+		return nil, err
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	for i, fr := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var prev *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			prev = cloneRGBA(canvas)
+		}
+		draw.Draw(canvas, fr.Bounds(), fr, fr.Bounds().Min, draw.Over)
+		a.frames = append(a.frames, cloneRGBA(canvas))
+		a.delays = append(a.delays, time.Duration(g.Delay[i])*10*time.Millisecond)
+		a.palettes = append(a.palettes, fr.Palette)
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, fr.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = prev
+		}
+	}
+	return a, nil
+}
+
+// paletted copy of 'img' by nearest colors of 'p', a transparent color is added if 'img' needs it
+func quantize(img *image.RGBA, p color.Palette) *image.Paletted {
+	if !img.Opaque() {
+		hasClear := false
+		for _, c := range p {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				hasClear = true
+				break
+			}
+		}
+		if !hasClear {
+			p = append(color.Palette{}, p...)
+			if len(p) == 256 {
+				p = p[:255]
+			}
+			p = append(p, color.RGBA{})
+		}
+	}
+	dst := image.NewPaletted(img.Bounds(), p)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst
+}
+
+func encodeGIF(w io.Writer, a *animation) error {
+	g := &gif.GIF{}
+	switch a.plays {
+	case 0:
+	case 1:
+		g.LoopCount = -1
+	default:
+		g.LoopCount = a.plays - 1
+	}
+	for i, frame := range a.frames {
+		p := color.Palette(palette.Plan9)
+		if i < len(a.palettes) && len(a.palettes[i]) > 0 {
+			p = a.palettes[i]
+		}
+		disposal := byte(gif.DisposalNone)
+		if !frame.Opaque() {
+			disposal = gif.DisposalBackground
+		}
+		g.Image = append(g.Image, quantize(frame, p))
+		g.Delay = append(g.Delay, int(a.delays[i]/(10*time.Millisecond)))
+		g.Disposal = append(g.Disposal, disposal)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+// https://wiki.mozilla.org/APNG_Specification
+
+type pngChunkData struct {
+	typ  string
+	data []byte
+}
+
+// chunks of PNG 'data' after signature
+func pngChunks(data []byte) (chunks []pngChunkData) {
+	for p := len(pngSig); p+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[p:]))
+		if n < 0 || p+12+n > len(data) {
+			break
+		}
+		chunks = append(chunks, pngChunkData{string(data[p+4 : p+8]), data[p+8 : p+8+n]})
+		p += 12 + n
+	}
+	return chunks
+}
+
+// data of first chunk 'typ' before image data, nil if absent
+func pngChunk(data []byte, typ string) []byte {
+	for _, c := range pngChunks(data) {
+		if c.typ == typ {
+			return c.data
+		}
+		if c.typ == "IDAT" {
+			break
+		}
+	}
+	return nil
+}
+
+func writePNGChunk(w io.Writer, typ string, data []byte) {
+	head := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	head = append(head, typ...)
+	crc := crc32.NewIEEE()
+	crc.Write(head[4:])
+	crc.Write(data)
+	w.Write(head)
+	w.Write(data)
+	w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+}
+
+type apngFrame struct {
+	x, y, w, h    int
+	delay         time.Duration
+	dispose, over bool // dispose to background, blend over previous
+	previous      bool // dispose to previous
+	data          [][]byte
+}
+
+func decodeAPNG(data []byte) (*animation, error) {
+	chunks := pngChunks(data)
+	var (
+		ihdr   []byte
+		shared []pngChunkData // chunks before image data, such as palette
+		frames []*apngFrame
+		cur    *apngFrame
+		a      = &animation{format: "png"}
+	)
+	for _, c := range chunks {
+		switch c.typ {
+		case "IHDR":
+			ihdr = c.data
+		case "acTL":
+			if len(c.data) >= 8 {
+				a.plays = int(binary.BigEndian.Uint32(c.data[4:]))
+			}
+		case "fcTL":
+			if len(c.data) < 26 {
+				return nil, fmt.Errorf("APNG frame control is broken")
+			}
+			d := c.data
+			num, den := binary.BigEndian.Uint16(d[20:]), binary.BigEndian.Uint16(d[22:])
+			if den == 0 {
+				den = 100
+			}
+			cur = &apngFrame{
+				w: int(binary.BigEndian.Uint32(d[4:])), h: int(binary.BigEndian.Uint32(d[8:])),
+				x: int(binary.BigEndian.Uint32(d[12:])), y: int(binary.BigEndian.Uint32(d[16:])),
+				delay:    time.Duration(num) * time.Second / time.Duration(den),
+				dispose:  d[24] == 1,
+				previous: d[24] == 2,
+				over:     d[25] == 1,
+			}
+			frames = append(frames, cur)
+		case "IDAT":
+			if cur != nil { // default image is the first frame
+				cur.data = append(cur.data, c.data)
+			}
+		case "fdAT":
+			if cur != nil && len(c.data) > 4 {
+				cur.data = append(cur.data, c.data[4:])
+			}
+		case "IEND":
+		default:
+			if len(frames) == 0 {
+				shared = append(shared, c)
+			}
+		}
+	}
+	if len(ihdr) < 13 {
+		return nil, fmt.Errorf("PNG header is missing")
+	}
+
+	w, h := int(binary.BigEndian.Uint32(ihdr)), int(binary.BigEndian.Uint32(ihdr[4:]))
+	if err := checkAnimation(w, h, len(frames)); err != nil {
+		return nil, err
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, fr := range frames {
+		if !image.Rect(fr.x, fr.y, fr.x+fr.w, fr.y+fr.h).In(canvas.Bounds()) || fr.w <= 0 || fr.h <= 0 {
+			return nil, fmt.Errorf("APNG frame %d is out of %dx%d", i, w, h)
+		}
+		// each frame is a standalone PNG of its own size
+		buf := bytes.NewBuffer(append([]byte{}, pngSig...))
+		hdr := append([]byte{}, ihdr...)
+		binary.BigEndian.PutUint32(hdr, uint32(fr.w))
+		binary.BigEndian.PutUint32(hdr[4:], uint32(fr.h))
+		writePNGChunk(buf, "IHDR", hdr)
+		for _, c := range shared {
+			writePNGChunk(buf, c.typ, c.data)
+		}
+		for _, d := range fr.data {
+			writePNGChunk(buf, "IDAT", d)
+		}
+		writePNGChunk(buf, "IEND", nil)
+		img, err := png.Decode(buf)
+		if err != nil {
+			return nil, fmt.Errorf("APNG frame %d: %w", i, err)
+		}
+
+		rect := image.Rect(fr.x, fr.y, fr.x+fr.w, fr.y+fr.h)
+		var prev *image.RGBA
+		if fr.previous && i > 0 {
+			prev = cloneRGBA(canvas)
+		}
+		op := draw.Src
+		if fr.over {
+			op = draw.Over
+		}
+		draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+		a.frames = append(a.frames, cloneRGBA(canvas))
+		a.delays = append(a.delays, fr.delay)
+		switch {
+		case fr.dispose, fr.previous && i == 0:
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		case fr.previous:
+			canvas = prev
+		}
+	}
+	return a, nil
+}
+
+// forces RGBA color type, so frames with & without transparency share one header
+type alphaImage struct{ *image.RGBA }
+
+func (alphaImage) Opaque() bool { return false }
+
+func encodeAPNG(w io.Writer, a *animation) error {
+	opaque := true
+	for _, frame := range a.frames {
+		opaque = opaque && frame.Opaque()
+	}
+	b := a.frames[0].Bounds()
+	out := bytes.NewBuffer(append([]byte{}, pngSig...))
+	seq := uint32(0)
+	for i, frame := range a.frames {
+		var img image.Image = frame
+		if !opaque {
+			img = alphaImage{frame}
+		}
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, img); err != nil {
+			return err
+		}
+		chunks := pngChunks(buf.Bytes())
+		if i == 0 {
+			writePNGChunk(out, "IHDR", chunks[0].data)
+			actl := binary.BigEndian.AppendUint32(nil, uint32(len(a.frames)))
+			writePNGChunk(out, "acTL", binary.BigEndian.AppendUint32(actl, uint32(a.plays)))
+		}
+		fctl := binary.BigEndian.AppendUint32(nil, seq)
+		for _, v := range []int{b.Dx(), b.Dy(), 0, 0} {
+			fctl = binary.BigEndian.AppendUint32(fctl, uint32(v))
+		}
+		fctl = binary.BigEndian.AppendUint16(fctl, uint16(a.delays[i].Milliseconds()))
+		fctl = binary.BigEndian.AppendUint16(fctl, 1000)
+		fctl = append(fctl, 0, 0) // no disposal, source blending as frames are full canvas
+		writePNGChunk(out, "fcTL", fctl)
+		seq++
+		for _, c := range chunks {
+			switch {
+			case c.typ != "IDAT":
+			case i == 0:
+				writePNGChunk(out, "IDAT", c.data)
+			default:
+				writePNGChunk(out, "fdAT", append(binary.BigEndian.AppendUint32(nil, seq), c.data...))
+				seq++
+			}
+		}
+	}
+	writePNGChunk(out, "IEND", nil)
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// save 'a' as 'format', "gif" or "png" (APNG)
+func (a *animation) save(path, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if format == "gif" {
+		return encodeGIF(f, a)
+	}
+	return encodeAPNG(f, a)
+}
+
+func saveGIF(img image.Image, path string) (image.Image, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	if err := gif.Encode(out, img, nil); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+// "root/name/.fi/id/poster.png"
+func (us *UserSpace) posterPath(fi *fdb.FileItem) string {
+	return filepath.Join(us.artifactDir(fi), "poster.png")
+}
+
+// render static poster frame of video or animated image 'fi' if missing or stale, return its path.
+// Still image is its own poster.
+func (us *UserSpace) renderPoster(fi *fdb.FileItem) (string, error) {
+	path := us.posterPath(fi)
+	switch {
+	case fi.Type() == fd.Image && fi.Frames() < 2:
+		return fi.Path, nil
+	case thumbFresh(fi, path):
+		return path, nil
+	}
+	fd.MustCreateDir(us.artifactDir(fi))
+	switch fi.Type() {
+	case fd.Video:
+		return path, videoFrame(fi.Path, path, posterAt)
+	case fd.Image:
+		a, err := loadAnimation(fi.Path)
+		if err != nil {
+			return "", err
+		}
+		if a == nil {
+			return fi.Path, nil
+		}
+		_, err = savePNG(a.frames[0], path)
+		return path, err
+	}
+	return "", fmt.Errorf("[%s] is %s, no poster", fi.Id, fi.Type())
+}
+
+// Poster returns path of static frame of first FileItem matching 'id': first frame of animated image,
+// frame of video, or the still image itself
+func (us *UserSpace) Poster(id string) (string, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return "", err
+	}
+	if len(fis) == 0 {
+		return "", fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	return us.renderPoster(fis[0])
+}
@@ -0,0 +1,140 @@
+package filemgr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lk "github.com/digisan/logkit"
+)
+
+var testColors = []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+
+// 3 frames of 40x20 in 'testColors', 2nd frame only covers left half, 100ms each
+func testGIF() *bytes.Buffer {
+	p := color.Palette{testColors[0], testColors[1], testColors[2], color.RGBA{}}
+	g := &gif.GIF{}
+	for i, rect := range []image.Rectangle{image.Rect(0, 0, 40, 20), image.Rect(0, 0, 20, 20), image.Rect(0, 0, 40, 20)} {
+		fr := image.NewPaletted(rect, p)
+		for j := range fr.Pix {
+			fr.Pix[j] = uint8(i)
+		}
+		g.Image = append(g.Image, fr)
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := &bytes.Buffer{}
+	lk.FailOnErr("%v", gif.EncodeAll(buf, g))
+	return buf
+}
+
+func rgbaAt(img image.Image, x, y int) color.RGBA {
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
+
+func TestAnimation(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("animation test")
+	lk.FailOnErr("%v", err)
+
+	// composited frames
+	a, err := decodeGIF(testGIF())
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(a.frames) != 3 || a.duration() != 300*time.Millisecond, "%v", fmt.Errorf("frames %d, duration %v", len(a.frames), a.duration()))
+	lk.FailOnErrWhen(rgbaAt(a.frames[1], 5, 5) != testColors[1] || rgbaAt(a.frames[1], 35, 5) != testColors[0], "%v",
+		fmt.Errorf("partial frame MUST be drawn over previous"))
+
+	// crop keeps animation
+	po := &ProcessOptions{Crop: &Rect{X: 20, Y: 0, W: 20, H: 20}}
+	path, err := us.SaveFile(testGIF(), "spin.gif", "", po, false, "anim")
+	lk.FailOnErr("%v", err)
+	fi := us.FIs[len(us.FIs)-1]
+	fmt.Println(path, fi.Meta)
+	lk.FailOnErrWhen(filepath.Ext(path) != ".gif" || fi.Frames() != 3 || fi.Duration() != 300*time.Millisecond, "%v",
+		fmt.Errorf("animated gif expected, %s %d frames %v", path, fi.Frames(), fi.Duration()))
+	cropped, err := loadAnimation(path)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(cropped.frames[0].Bounds().Dx() != 20 || rgbaAt(cropped.frames[1], 10, 10) != testColors[0], "%v",
+		fmt.Errorf("cropped frames %v %v", cropped.frames[0].Bounds(), rgbaAt(cropped.frames[1], 10, 10)))
+
+	// resize keeps animation on derived rendition
+	dfi, err := us.TransformImage(fi.Id, &ImageTransform{Resize: ResizeFit, Width: 10}, true)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(dfi.Frames() != 3 || dfi.Meta.Width != 10, "%v", fmt.Errorf("resized %v", dfi.Meta))
+
+	// poster is first frame
+	poster, err := us.Poster(fi.Id)
+	lk.FailOnErr("%v", err)
+	img, err := loadImage(poster)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(rgbaAt(img, 10, 10) != testColors[0], "%v", fmt.Errorf("poster %v", rgbaAt(img, 10, 10)))
+
+	// APNG round trip through resize
+	apng := &bytes.Buffer{}
+	lk.FailOnErr("%v", encodeAPNG(apng, a))
+	path, err = us.SaveFile(apng, "spin.png", "", &ProcessOptions{Width: 20}, false, "anim")
+	lk.FailOnErr("%v", err)
+	fi = us.FIs[len(us.FIs)-1]
+	fmt.Println(path, fi.Meta)
+	resized, err := loadAnimation(path)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(resized == nil || len(resized.frames) != 3 || resized.frames[0].Bounds().Dx() != 20 || fi.Frames() != 3, "%v",
+		fmt.Errorf("APNG MUST keep 3 frames at 20 width"))
+	lk.FailOnErrWhen(rgbaAt(resized.frames[2], 15, 5) != testColors[2], "%v", fmt.Errorf("APNG frame color %v", rgbaAt(resized.frames[2], 15, 5)))
+
+	// jpg flattens to first frame
+	path, err = us.SaveFile(testGIF(), "flat.gif", "", &ProcessOptions{Format: "jpg"}, false, "anim")
+	lk.FailOnErr("%v", err)
+	data, err := os.ReadFile(path)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(us.FIs[len(us.FIs)-1].Frames() != 0 || !bytes.HasPrefix(data, []byte{0xFF, 0xD8}), "%v", fmt.Errorf("jpg MUST be still"))
+}
+
+func TestAnimationLimits(t *testing.T) {
+
+	// too many frames
+	p := color.Palette{testColors[0], testColors[1]}
+	g := &gif.GIF{}
+	for i := 0; i <= maxFrames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), p))
+		g.Delay = append(g.Delay, 1)
+	}
+	buf := &bytes.Buffer{}
+	lk.FailOnErr("%v", gif.EncodeAll(buf, g))
+	_, err := decodeGIF(buf)
+	fmt.Println(err)
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("%d frames MUST be rejected", maxFrames+1))
+
+	// huge canvas declared by header
+	apng := bytes.NewBuffer(append([]byte{}, pngSig...))
+	writePNGChunk(apng, "IHDR", []byte{0, 1, 0, 0, 0, 1, 0, 0, 8, 6, 0, 0, 0}) // 65536x65536
+	writePNGChunk(apng, "acTL", []byte{0, 0, 0, 2, 0, 0, 0, 0})
+	fctl := make([]byte, 26)
+	fctl[7], fctl[11] = 1, 1 // 1x1 frame
+	writePNGChunk(apng, "fcTL", fctl)
+	writePNGChunk(apng, "fcTL", fctl)
+	writePNGChunk(apng, "IEND", nil)
+	_, err = decodeAPNG(apng.Bytes())
+	fmt.Println(err)
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("huge canvas MUST be rejected"))
+
+	// pixels of all frames overflowing int
+	apng = bytes.NewBuffer(append([]byte{}, pngSig...))
+	writePNGChunk(apng, "IHDR", []byte{8, 0, 0, 0, 8, 0, 0, 0, 8, 6, 0, 0, 0}) // 2^27 x 2^27
+	writePNGChunk(apng, "acTL", []byte{0, 0, 3, 0xe8, 0, 0, 0, 0})
+	for i := 0; i < maxFrames; i++ {
+		writePNGChunk(apng, "fcTL", fctl)
+	}
+	writePNGChunk(apng, "IEND", nil)
+	_, err = decodeAPNG(apng.Bytes())
+	fmt.Println(err)
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("overflowing canvas MUST be rejected"))
+	lk.FailOnErrWhen(checkAnimation(1<<27, 1<<27, maxFrames) == nil, "%v", fmt.Errorf("overflowing pixels MUST be rejected"))
+}
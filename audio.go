@@ -0,0 +1,368 @@
+package filemgr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/digisan/file-mgr/fdb"
+)
+
+const (
+	FmtMP3  = "mp3"  // MPEG layer 3 audio, plays everywhere
+	FmtOpus = "opus" // Opus audio in Ogg, smaller at same quality
+
+	waveformPeaks = 200  // peaks of waveform stored with FileItem
+	waveformRate  = 8000 // Hz of mono samples decoded by ffmpeg for waveform
+	maxChannels   = 32   // channels of WAV read natively
+)
+
+// fill tags of 'meta' still empty, 'year' may be a date & 'track' may be "3/12"
+func setTags(meta *fdb.Meta, title, artist, album, year, genre, track string) {
+	set := func(dst *string, v string) {
+		if *dst == "" {
+			*dst = strings.TrimSpace(v)
+		}
+	}
+	set(&meta.Title, title)
+	set(&meta.Artist, artist)
+	set(&meta.Album, album)
+	if len(year) >= 4 {
+		set(&meta.Year, year[:4])
+	}
+	set(&meta.Genre, genre)
+	if meta.Track == 0 {
+		no, _, _ := strings.Cut(track, "/")
+		meta.Track, _ = strconv.Atoi(strings.TrimSpace(no))
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+// https://id3.org/id3v2.3.0
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// text of ID3v2 text frame, first of NUL separated values
+func id3Text(data []byte) string {
+	if len(data) < 2 {
+		return ""
+	}
+	text, charset := data[1:], CharsetLatin1
+	switch data[0] {
+	case 1:
+		switch {
+		case bytes.HasPrefix(text, []byte{0xFF, 0xFE}):
+			text, charset = text[2:], CharsetUTF16LE
+		case bytes.HasPrefix(text, []byte{0xFE, 0xFF}):
+			text, charset = text[2:], CharsetUTF16BE
+		default:
+			charset = CharsetUTF16LE
+		}
+	case 2:
+		charset = CharsetUTF16BE
+	case 3:
+		charset = CharsetUTF8
+	}
+	s, _, _ := strings.Cut(decodeText(text, charset), "\x00")
+	return strings.TrimSpace(s)
+}
+
+// tags of ID3v2 header at start, or ID3v1 trailer at end of 'fPath' into 'meta'
+func parseID3(fPath string, meta *fdb.Meta) error {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(f, head); err == nil && string(head[:3]) == "ID3" && head[3] >= 2 && head[3] <= 4 {
+		ver, flags := head[3], head[5]
+		body := make([]byte, min(int64(syncsafe(head[6:])), info.Size()-10)) // tag is within file
+		if _, err := io.ReadFull(f, body); err != nil {
+			return fmt.Errorf("ID3 tag is broken: %v", err)
+		}
+		if flags&0x80 != 0 && ver < 4 { // unsynchronisation, 0xFF 0x00 stands for 0xFF
+			body = bytes.ReplaceAll(body, []byte{0xFF, 0x00}, []byte{0xFF})
+		}
+		if flags&0x40 != 0 && ver >= 3 && len(body) >= 4 { // extended header
+			n := int(binary.BigEndian.Uint32(body)) + 4
+			if ver == 4 {
+				n = syncsafe(body)
+			}
+			body = body[min(n, len(body)):]
+		}
+
+		idLen, hdrLen := 4, 10
+		if ver == 2 {
+			idLen, hdrLen = 3, 6
+		}
+		frames := map[string]string{}
+		for p := 0; p+hdrLen <= len(body) && body[p] != 0; {
+			id, n := string(body[p:p+idLen]), 0
+			switch ver {
+			case 2:
+				n = int(body[p+3])<<16 | int(body[p+4])<<8 | int(body[p+5])
+			case 3:
+				n = int(binary.BigEndian.Uint32(body[p+4:]))
+			case 4:
+				n = syncsafe(body[p+4:])
+			}
+			p += hdrLen
+			if n < 0 || p+n > len(body) {
+				break
+			}
+			if id[0] == 'T' {
+				frames[id] = id3Text(body[p : p+n])
+			}
+			p += n
+		}
+		pick := func(ids ...string) string {
+			for _, id := range ids {
+				if v := frames[id]; v != "" {
+					return v
+				}
+			}
+			return ""
+		}
+		setTags(meta, pick("TIT2", "TT2"), pick("TPE1", "TP1"), pick("TALB", "TAL"),
+			pick("TDRC", "TYER", "TYE"), pick("TCON", "TCO"), pick("TRCK", "TRK"))
+	}
+
+	// ID3v1 fills what v2 misses
+	if info.Size() < 128 {
+		return nil
+	}
+	tail := make([]byte, 128)
+	if _, err := f.ReadAt(tail, info.Size()-128); err != nil {
+		return err
+	}
+	if string(tail[:3]) != "TAG" {
+		return nil
+	}
+	field := func(b []byte) string {
+		s, _, _ := strings.Cut(decodeText(b, CharsetLatin1), "\x00")
+		return s
+	}
+	track := ""
+	if tail[125] == 0 && tail[126] != 0 { // ID3v1.1
+		track = strconv.Itoa(int(tail[126]))
+	}
+	setTags(meta, field(tail[3:33]), field(tail[33:63]), field(tail[63:93]), field(tail[93:97]), "", track)
+	return nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+// PCM stream of WAV file
+type wavInfo struct {
+	format, channels, rate, bits int
+	offset, size                 int64 // of sample data
+}
+
+// sample format & data position of WAV 'r' of 'size' bytes, nil if it is NOT WAV.
+// data size is clamped to the file, as streaming WAV declares 0 or 0xFFFFFFFF
+func parseWAV(r io.ReaderAt, size int64) (*wavInfo, error) {
+	head := make([]byte, 12)
+	if _, err := r.ReadAt(head, 0); err != nil || string(head[:4]) != "RIFF" || string(head[8:]) != "WAVE" {
+		return nil, nil
+	}
+	w := &wavInfo{}
+	for p := int64(12); ; {
+		ch := make([]byte, 8)
+		if _, err := r.ReadAt(ch, p); err != nil {
+			return nil, fmt.Errorf("WAV data chunk is missing")
+		}
+		id, n := string(ch[:4]), int64(binary.LittleEndian.Uint32(ch[4:]))
+		p += 8
+		switch id {
+		case "fmt ":
+			fmtc := make([]byte, 16)
+			if _, err := r.ReadAt(fmtc, p); err != nil {
+				return nil, fmt.Errorf("WAV format chunk is broken")
+			}
+			w.format = int(binary.LittleEndian.Uint16(fmtc))
+			w.channels = int(binary.LittleEndian.Uint16(fmtc[2:]))
+			w.rate = int(binary.LittleEndian.Uint32(fmtc[4:]))
+			w.bits = int(binary.LittleEndian.Uint16(fmtc[14:]))
+		case "data":
+			if w.channels == 0 || w.rate == 0 || w.bits == 0 {
+				return nil, fmt.Errorf("WAV format chunk is missing")
+			}
+			if w.channels > maxChannels {
+				return nil, fmt.Errorf("WAV of %d channels exceeds %d", w.channels, maxChannels)
+			}
+			if n == 0 || n > size-p {
+				n = max(0, size-p)
+			}
+			w.offset, w.size = p, n
+			return w, nil
+		}
+		p += n + n%2 // chunks are word aligned
+	}
+}
+
+// integer PCM of 8, 16, 24 or 32 bits, or extensible format holding it
+func (w *wavInfo) pcm() bool {
+	return (w.format == 1 || w.format == 0xFFFE) && w.bits%8 == 0 && w.bits >= 8 && w.bits <= 32
+}
+
+func (w *wavInfo) frames() int64 {
+	return w.size / int64(w.channels*w.bits/8)
+}
+
+// peak collector of 'n' buckets over 'total' samples
+type peaks struct {
+	values []float64
+	total  int64
+	count  int64
+}
+
+func newPeaks(n int, total int64) *peaks {
+	return &peaks{values: make([]float64, n), total: max(1, total)}
+}
+
+// add amplitude 'v' in 0-1 of next sample
+func (pk *peaks) add(v float64) {
+	i := min(int64(len(pk.values)-1), pk.count*int64(len(pk.values))/pk.total)
+	pk.values[i] = max(pk.values[i], v)
+	pk.count++
+}
+
+// peaks as base64 bytes, 255 is full scale
+func (pk *peaks) encode() string {
+	data := make([]byte, len(pk.values))
+	for i, v := range pk.values {
+		data[i] = byte(math.Round(min(1, v) * 255))
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// peaks of PCM WAV 'f' from its samples, loudest channel counts
+func (w *wavInfo) peaks(f io.ReaderAt, n int) (*peaks, error) {
+	size := w.bits / 8
+	frame := size * w.channels
+	pk := newPeaks(n, w.frames())
+	full := math.Pow(2, float64(w.bits-1))
+	buf := make([]byte, 64<<10)
+	buf = buf[:len(buf)-len(buf)%frame]
+	for p := int64(0); p < w.frames()*int64(frame); {
+		m, err := f.ReadAt(buf[:min(int64(len(buf)), w.frames()*int64(frame)-p)], w.offset+p)
+		if m == 0 && err != nil {
+			return nil, err
+		}
+		m -= m % frame
+		for i := 0; i < m; i += frame {
+			peak := 0.0
+			for c := 0; c < w.channels; c++ {
+				s := buf[i+c*size : i+(c+1)*size]
+				v := 0
+				if size == 1 { // 8 bits is unsigned
+					v = int(s[0]) - 128
+				} else {
+					for k := size - 1; k >= 0; k-- {
+						v = v<<8 | int(s[k])
+					}
+					v = v << (64 - 8*size) >> (64 - 8*size) // sign extension
+				}
+				peak = max(peak, math.Abs(float64(v))/full)
+			}
+			pk.add(peak)
+		}
+		p += int64(m)
+	}
+	return pk, nil
+}
+
+// sudo apt install ffmpeg
+// peaks of any audio by decoding it to mono samples with ffmpeg, 'duration' in seconds sizes buckets
+func ffmpegPeaks(fPath string, duration float64, n int) (*peaks, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration is unknown")
+	}
+	cmd := exec.Command("ffmpeg", "-v", "quiet", "-nostdin", "-i", fPath, "-ac", "1", "-ar", strconv.Itoa(waveformRate), "-f", "s16le", "-")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v", err)
+	}
+	pk := newPeaks(n, int64(duration*waveformRate))
+	buf := make([]byte, 2*4096)
+	for {
+		m, err := io.ReadFull(stdout, buf)
+		for i := 0; i+1 < m; i += 2 {
+			pk.add(math.Abs(float64(int16(binary.LittleEndian.Uint16(buf[i:])))) / 32768)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v", err)
+	}
+	return pk, nil
+}
+
+// duration, stream, tags & waveform of audio 'fPath' into 'meta'. PCM WAV & ID3 are read natively,
+// others need ffprobe & ffmpeg
+func extractAudio(fPath string, meta *fdb.Meta) error {
+	if err := parseID3(fPath, meta); err != nil {
+		return err
+	}
+	f, err := os.Open(fPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := parseWAV(f, info.Size())
+	if err != nil {
+		return err
+	}
+	if w != nil && w.pcm() {
+		meta.Codec = fmt.Sprintf("pcm_s%dle", w.bits)
+		if w.bits == 8 {
+			meta.Codec = "pcm_u8"
+		}
+		meta.SampleRate, meta.Channels = w.rate, w.channels
+		meta.Bitrate = w.rate * w.channels * w.bits
+		meta.Duration = float64(w.frames()) / float64(w.rate)
+		pk, err := w.peaks(f, waveformPeaks)
+		if err != nil {
+			return err
+		}
+		meta.Peaks = pk.encode()
+		return nil
+	}
+
+	if err := probeMedia(fPath, meta); err != nil {
+		return err
+	}
+	pk, err := ffmpegPeaks(fPath, meta.Duration, waveformPeaks)
+	if err != nil {
+		return err
+	}
+	meta.Peaks = pk.encode()
+	return nil
+}
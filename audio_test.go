@@ -0,0 +1,137 @@
+package filemgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/digisan/file-mgr/fdb"
+	lk "github.com/digisan/logkit"
+)
+
+// 16 bits mono PCM WAV of 440Hz tone fading in over 'seconds'
+func testWAV(rate int, seconds float64) *bytes.Buffer {
+	n := int(float64(rate) * seconds)
+	data := make([]byte, 0, 2*n)
+	for i := 0; i < n; i++ {
+		v := math.Sin(2*math.Pi*440*float64(i)/float64(rate)) * float64(i) / float64(n)
+		data = binary.LittleEndian.AppendUint16(data, uint16(int16(v*32767)))
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(36+len(data)))
+	buf.WriteString("WAVEfmt ")
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(rate * 2), uint16(2), uint16(16)} {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	return buf
+}
+
+// ID3v2.3 text frames, fake MPEG frames, then ID3v1 trailer
+func testMP3() *bytes.Buffer {
+	frames := &bytes.Buffer{}
+	for _, f := range [][2]string{{"TIT2", "\x03Héllo"}, {"TPE1", "\x01\xff\xfeA\x00B\x00"}, {"TRCK", "\x003/12"}, {"TYER", "\x002021"}} {
+		frames.WriteString(f[0])
+		binary.Write(frames, binary.BigEndian, uint32(len(f[1])))
+		frames.Write([]byte{0, 0})
+		frames.WriteString(f[1])
+	}
+	n := frames.Len()
+	buf := bytes.NewBufferString("ID3\x03\x00\x00")
+	buf.Write([]byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)})
+	buf.Write(frames.Bytes())
+	for i := 0; i < 8; i++ {
+		buf.Write([]byte{0xFF, 0xFB, 0x90, 0x64})
+		buf.Write(make([]byte, 413))
+	}
+	tail := make([]byte, 128)
+	copy(tail, "TAG")
+	copy(tail[3:], "v1 title")
+	copy(tail[63:], "Greatest Hits")
+	buf.Write(tail)
+	return buf
+}
+
+func TestAudio(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("audio test")
+	lk.FailOnErr("%v", err)
+
+	// WAV is read natively
+	_, err = us.SaveFile(testWAV(8000, 2), "tone.wav", "", nil, false, "music")
+	lk.FailOnErr("%v", err)
+	wav := us.FIs[len(us.FIs)-1]
+	fmt.Println(wav.Meta)
+	m := wav.Meta
+	lk.FailOnErrWhen(m.SampleRate != 8000 || m.Channels != 1 || m.Bitrate != 128000 || wav.Duration().Seconds() != 2 || m.Codec != "pcm_s16le", "%v",
+		fmt.Errorf("wav meta %+v", m))
+	peaks := wav.Waveform()
+	lk.FailOnErrWhen(len(peaks) != waveformPeaks || peaks[0] > 0.05 || peaks[len(peaks)-1] < 0.95, "%v", fmt.Errorf("fading in waveform %v", peaks))
+	lk.FailOnErrWhen(!slices.IsSorted(peaks[10:]), "%v", fmt.Errorf("fading in waveform MUST rise %v", peaks))
+
+	// ID3 v2 tags, v1 fills the rest
+	_, err = us.SaveFile(testMP3(), "song.mp3", "", nil, false, "music")
+	lk.FailOnErr("%v", err)
+	mp3 := us.FIs[len(us.FIs)-1]
+	fmt.Println(mp3.Type(), mp3.Meta)
+	m = mp3.Meta
+	lk.FailOnErrWhen(m.Title != "Héllo" || m.Artist != "AB" || m.Track != 3 || m.Year != "2021" || m.Album != "Greatest Hits", "%v",
+		fmt.Errorf("id3 tags %+v", m))
+
+	// transcoding
+	args := Rendition{Format: FmtOpus}.args("in.wav", "out.opus", 0, "")
+	lk.FailOnErrWhen(!strings.Contains(strings.Join(args, " "), "-vn -map_metadata 0 -c:a libopus"), "%v", fmt.Errorf("opus args %v", args))
+
+	fakeFFmpeg(t)
+	_, err = us.Transcode(wav.Id, nil, Rendition{Format: FmtMP4})
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("audio MUST NOT be rendered as video"))
+	rs, err := us.Transcode(wav.Id, nil)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(rs) != 1 || rs[0].Name() != "tone.mp3" || rs[0].SrcId != wav.Id, "%v", fmt.Errorf("mp3 rendition %v", rs))
+}
+
+func TestAudioLimits(t *testing.T) {
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		fPath := filepath.Join(dir, name)
+		lk.FailOnErr("%v", os.WriteFile(fPath, data, 0o644))
+		return fPath
+	}
+
+	// streaming WAV declares no data size, real size counts
+	for _, size := range []uint32{0, 0xFFFFFFFF} {
+		data := testWAV(8000, 2).Bytes()
+		binary.LittleEndian.PutUint32(data[40:], size)
+		meta := &fdb.Meta{}
+		lk.FailOnErr("%v", extractAudio(write("stream.wav", data), meta))
+		lk.FailOnErrWhen(meta.Duration != 2 || meta.Peaks == "", "%v", fmt.Errorf("streaming wav meta %+v", meta))
+	}
+
+	// too many channels
+	data := testWAV(8000, 2).Bytes()
+	binary.LittleEndian.PutUint16(data[22:], 0xFFFF)
+	binary.LittleEndian.PutUint16(data[34:], 32)
+	err := extractAudio(write("channels.wav", data), &fdb.Meta{})
+	fmt.Println(err)
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("%d channels MUST be rejected", 0xFFFF))
+
+	// ID3 tag size beyond file is clamped
+	data = testMP3().Bytes()
+	copy(data[6:], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	lk.FailOnErrWhen(syncsafe(data[6:]) != 1<<28-1, "%v", fmt.Errorf("syncsafe %d", syncsafe(data[6:])))
+	meta := &fdb.Meta{}
+	lk.FailOnErr("%v", parseID3(write("huge.mp3", data), meta))
+	lk.FailOnErrWhen(meta.Title != "Héllo", "%v", fmt.Errorf("id3 meta %+v", meta))
+}
@@ -0,0 +1,126 @@
+package filemgr
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+const (
+	base83      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+	blurhashX   = 4  // horizontal components
+	blurhashY   = 3  // vertical components
+	placeholder = 64 // longest edge of downscaled image for placeholders
+)
+
+func encode83(v, length int) string {
+	sb := strings.Builder{}
+	for i := length; i > 0; i-- {
+		sb.WriteByte(base83[v/int(math.Pow(83, float64(i-1)))%83])
+	}
+	return sb.String()
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) int {
+	v = max(0, min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// blurhash of 'img' with 'cx' x 'cy' components, each 1-9
+func blurhash(img image.Image, cx, cy int) string {
+	small := fitImage(img, placeholder)
+	w, h := small.Bounds().Dx(), small.Bounds().Dy()
+
+	factors := make([][3]float64, 0, cx*cy)
+	for j := 0; j < cy; j++ {
+		for i := 0; i < cx; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			f := [3]float64{}
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := norm * math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					p := small.Pix[y*small.Stride+x*4:]
+					f[0] += basis * srgbToLinear(p[0])
+					f[1] += basis * srgbToLinear(p[1])
+					f[2] += basis * srgbToLinear(p[2])
+				}
+			}
+			for k := range f {
+				f[k] /= float64(w * h)
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	dc, ac := factors[0], factors[1:]
+	sb := strings.Builder{}
+	sb.WriteString(encode83((cx-1)+(cy-1)*9, 1))
+
+	maxAC, quantMax := 1.0, 0
+	if len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = max(actual, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantMax = int(max(0, min(82, math.Floor(actual*166-0.5))))
+		maxAC = float64(quantMax+1) / 166
+	}
+	sb.WriteString(encode83(quantMax, 1))
+	sb.WriteString(encode83(linearToSrgb(dc[0])<<16+linearToSrgb(dc[1])<<8+linearToSrgb(dc[2]), 4))
+
+	quant := func(v float64) int {
+		return int(max(0, min(18, math.Floor(signPow(v/maxAC, 0.5)*9+9.5))))
+	}
+	for _, f := range ac {
+		sb.WriteString(encode83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+	return sb.String()
+}
+
+// most frequent color of 'img' as "#rrggbb", averaged within its 4 bits per channel bucket
+func dominantColor(img image.Image) string {
+	small := fitImage(img, placeholder)
+	type bucket struct{ n, r, g, b int }
+	buckets := map[int]*bucket{}
+	var top *bucket
+	for i := 0; i+3 < len(small.Pix); i += 4 {
+		r, g, b, a := int(small.Pix[i]), int(small.Pix[i+1]), int(small.Pix[i+2]), small.Pix[i+3]
+		if a < 128 { // ignore transparent pixels
+			continue
+		}
+		key := r>>4<<8 | g>>4<<4 | b>>4
+		bk, ok := buckets[key]
+		if !ok {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.n, bk.r, bk.g, bk.b = bk.n+1, bk.r+r, bk.g+g, bk.b+b
+		if top == nil || bk.n > top.n {
+			top = bk
+		}
+	}
+	if top == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", top.r/top.n, top.g/top.n, top.b/top.n)
+}
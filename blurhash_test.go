@@ -0,0 +1,55 @@
+package filemgr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	lk "github.com/digisan/logkit"
+)
+
+func TestPlaceholder(t *testing.T) {
+
+	InitFileMgr("./data")
+	OptThumbOnSave(false)
+	defer OptThumbOnSave(true)
+
+	us, err := UseUser("placeholder test")
+	lk.FailOnErr("%v", err)
+
+	img := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 30; x++ {
+			img.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	lk.FailOnErr("%v", png.Encode(buf, img))
+	_, err = us.SaveFile(buf, "red.png", "", nil, false, "ph")
+	lk.FailOnErr("%v", err)
+	red := us.FIs[len(us.FIs)-1]
+	hash, clr := red.Placeholder()
+	fmt.Println(hash, clr)
+
+	// 4x3 components, DC is pure red
+	lk.FailOnErrWhen(clr != "#ff0000", "%v", fmt.Errorf("dominant color %s", clr))
+	lk.FailOnErrWhen(len(hash) != 28 || hash[0] != 'L' || hash[2:6] != encode83(0xFF0000, 4), "%v", fmt.Errorf("blurhash %s", hash))
+
+	// left 2/3 blue, right 1/3 red
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			img.Set(x, y, color.RGBA{0, 0, 255, 255})
+		}
+	}
+	buf.Reset()
+	lk.FailOnErr("%v", png.Encode(buf, img))
+	_, err = us.SaveFile(buf, "blue.png", "", nil, false, "ph")
+	lk.FailOnErr("%v", err)
+	hash, clr = us.FIs[len(us.FIs)-1].Placeholder()
+	fmt.Println(hash, clr)
+	lk.FailOnErrWhen(clr != "#0000ff", "%v", fmt.Errorf("dominant color %s", clr))
+	lk.FailOnErrWhen(len(hash) != 28 || hash[2:6] == encode83(0xFF0000, 4), "%v", fmt.Errorf("blurhash %s", hash))
+}
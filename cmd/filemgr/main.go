@@ -0,0 +1,469 @@
+// filemgr is an admin tool for file-mgr installations, printing JSON to stdout.
+//
+//	filemgr [-root ./data] <command> [flags]
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	fm "github.com/digisan/file-mgr"
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+)
+
+type command struct {
+	usage string
+	run   func(fs *flag.FlagSet, args []string, w io.Writer) (any, error)
+}
+
+var commands = map[string]command{
+	"init":      {"create file space & database under root", cmdInit},
+	"users":     {"list users", cmdUsers},
+	"ls":        {"list user's files, [-type] [-groups g0/g1]", cmdLs},
+	"put":       {"save a local file into user space, -file [-note] [-ym] [-groups g0/g1]", cmdPut},
+	"get":       {"write file content to stdout or -o file, -id", cmdGet},
+	"rm":        {"delete file items, -id", cmdRm},
+	"note":      {"set note of file items, -id -note", cmdNote},
+	"mv-group":  {"change one group of file items, -id -index -group", cmdMvGroup},
+	"check":     {"self check user space", cmdCheck},
+	"reconcile": {"drop file items missing on disk & remove empty directories", cmdReconcile},
+	"export":    {"export user's file items as JSON, [-o file]", cmdExport},
+	"import":    {"import file items JSON from an export, -i file", cmdImport},
+	"stats":     {"count & size of files per type", cmdStats},
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		json.NewEncoder(os.Stderr).Encode(map[string]string{"error": err.Error()})
+		os.Exit(1)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: filemgr [-root dir] <command> [flags]")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func run(args []string, w io.Writer) error {
+	gfs := flag.NewFlagSet("filemgr", flag.ContinueOnError)
+	root := gfs.String("root", "./data", "root directory of file-mgr installation")
+	gfs.Usage = func() { usage(gfs.Output()) }
+	if err := gfs.Parse(args); err != nil {
+		return err
+	}
+	if gfs.NArg() == 0 {
+		usage(gfs.Output())
+		return errors.New("command is missing")
+	}
+
+	name := gfs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		usage(gfs.Output())
+		return fmt.Errorf("unknown command [%s]", name)
+	}
+
+	fm.InitFileMgr(*root)
+	defer fm.DisposeFileMgr()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	out, err := cmd.run(fs, gfs.Args()[1:], w)
+	if err != nil || out == nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+type item struct {
+	Id     string   `json:"id"`
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Path   string   `json:"path"`
+	Time   string   `json:"time"`
+	Groups []string `json:"groups"`
+	Note   string   `json:"note"`
+}
+
+func toItem(fi *fdb.FileItem) item {
+	groups := []string{}
+	if fi.GroupList != "" {
+		groups = strings.Split(fi.GroupList, fdb.SEP_GRP)
+	}
+	return item{
+		Id:     fi.ID(),
+		Name:   fi.Name(),
+		Type:   fi.Type(),
+		Path:   fi.Path,
+		Time:   fi.Tm.Format("2006-01-02T15:04:05.000Z07:00"),
+		Groups: groups,
+		Note:   fi.Note,
+	}
+}
+
+func toItems(fis []*fdb.FileItem) []item {
+	items := make([]item, 0, len(fis))
+	for _, fi := range fis {
+		items = append(items, toItem(fi))
+	}
+	return items
+}
+
+// "g0/g1/g2" => [g0 g1 g2]
+func splitGroups(groups string) []string {
+	if groups = strings.Trim(groups, "/"); groups == "" {
+		return nil
+	}
+	return strings.Split(groups, "/")
+}
+
+func userFlag(fs *flag.FlagSet) *string {
+	return fs.String("user", "", "user name (required)")
+}
+
+func useUser(name string) (*fm.UserSpace, error) {
+	if name == "" {
+		return nil, errors.New("-user is required")
+	}
+	return fm.UseUser(name)
+}
+
+func idFlag(fs *flag.FlagSet) *string {
+	return fs.String("id", "", "file item id or its prefix, at least 32 chars (required)")
+}
+
+func fileItems(us *fm.UserSpace, id string) ([]*fdb.FileItem, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) == 0 {
+		return nil, fmt.Errorf("file item [%s] not found", id)
+	}
+	return fis, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+func cmdInit(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	users, err := fm.ListUsers()
+	return map[string]any{"initialized": true, "users": len(users)}, err
+}
+
+func cmdUsers(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return fm.ListUsers()
+}
+
+func cmdLs(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	fType := fs.String("type", "any", "file type, such as image, video, document")
+	groups := fs.String("groups", "", "group wildcards separated by '/', such as 'g0/*/g?'")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	if grps := splitGroups(*groups); len(grps) > 0 {
+		return toItems(us.SearchFileItem(*fType, grps...)), nil
+	}
+	fis := []*fdb.FileItem{}
+	for _, fi := range us.FIs {
+		if *fType == "any" || *fType == fi.Type() {
+			fis = append(fis, fi)
+		}
+	}
+	return toItems(fis), nil
+}
+
+func cmdPut(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	file := fs.String("file", "", "local file to save (required)")
+	name := fs.String("name", "", "file name to save as, default is base name of -file")
+	note := fs.String("note", "", "note of file")
+	addYM := fs.Bool("ym", true, "store under year-month directory")
+	groups := fs.String("groups", "", "groups separated by '/', such as 'g0/g1'")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if *name == "" {
+		*name = filepath.Base(*file)
+	}
+	path, err := us.SaveFile(f, *name, *note, nil, *addYM, splitGroups(*groups)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range us.FIs {
+		if fi.Path == path {
+			return toItem(fi), nil
+		}
+	}
+	return map[string]string{"path": path}, nil
+}
+
+func cmdGet(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	id := idFlag(fs)
+	out := fs.String("o", "", "output file, default writes raw content to stdout")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	dl, err := us.OpenFile(*id)
+	if err != nil {
+		return nil, err
+	}
+	defer dl.Close()
+	if *out == "" {
+		_, err = io.Copy(w, dl)
+		return nil, err
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	n, err := io.Copy(f, dl)
+	return map[string]any{"file": *out, "bytes": n, "etag": dl.ETag}, err
+}
+
+func cmdRm(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	id := idFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := fileItems(us, *id)
+	if err != nil {
+		return nil, err
+	}
+	items := toItems(fis)
+	return map[string]any{"removed": items}, us.DelFileItem(*id)
+}
+
+func cmdNote(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	id := idFlag(fs)
+	note := fs.String("note", "", "new note")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := fileItems(us, *id)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.SetFINote(*id, *note); err != nil {
+		return nil, err
+	}
+	return toItems(fis), nil
+}
+
+func cmdMvGroup(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	id := idFlag(fs)
+	index := fs.Int("index", 0, "group index to change, appends when out of range")
+	group := fs.String("group", "", "new group name (required)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *group == "" {
+		return nil, errors.New("-group is required")
+	}
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := fileItems(us, *id)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.SetFIGroup(*id, *index, *group); err != nil {
+		return nil, err
+	}
+	if err := us.SelfCheck(true); err != nil {
+		return nil, err
+	}
+	return toItems(fis), nil
+}
+
+func cmdCheck(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	fm.OptCheckOnLoad(false)
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	missing := []item{}
+	for _, fi := range us.FIs {
+		if !fd.FileExists(fi.Path) {
+			missing = append(missing, toItem(fi))
+		}
+	}
+	return map[string]any{"ok": len(missing) == 0, "items": len(us.FIs), "missing": missing}, nil
+}
+
+func cmdReconcile(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	fm.OptCheckOnLoad(false)
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := us.Reconcile()
+	return map[string]any{"removed": toItems(removed), "items": len(us.FIs)}, err
+}
+
+func cmdExport(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	out := fs.String("o", "", "output file, default is stdout")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	if *out == "" {
+		return us.FIs, nil
+	}
+	data, err := json.MarshalIndent(us.FIs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		return nil, err
+	}
+	return map[string]any{"file": *out, "items": len(us.FIs)}, nil
+}
+
+// restore file item records of an export, whose files are still in user space
+func cmdImport(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := userFlag(fs)
+	in := fs.String("i", "", "JSON file from export (required)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	us, err := useUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		return nil, err
+	}
+	fis := []*fdb.FileItem{}
+	if err := json.Unmarshal(data, &fis); err != nil {
+		return nil, err
+	}
+	imported, skipped := []item{}, []item{}
+	for _, fi := range fis {
+		if !us.Own(fi) || !fd.FileExists(fi.Path) {
+			skipped = append(skipped, toItem(fi))
+			continue
+		}
+		if err := us.UpdateFileItem(fi, false); err != nil {
+			return nil, err
+		}
+		imported = append(imported, toItem(fi))
+	}
+	return map[string]any{"imported": imported, "skipped": skipped}, nil
+}
+
+func cmdStats(fs *flag.FlagSet, args []string, w io.Writer) (any, error) {
+	user := fs.String("user", "", "user name, default is all users")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	users := []string{*user}
+	if *user == "" {
+		var err error
+		if users, err = fm.ListUsers(); err != nil {
+			return nil, err
+		}
+	}
+
+	type stat struct {
+		Count int   `json:"count"`
+		Bytes int64 `json:"bytes"`
+	}
+	type userStat struct {
+		Types   map[string]*stat `json:"types"`
+		Missing []item           `json:"missing"` // file items missing on disk, not counted
+	}
+	fm.OptCheckOnLoad(false)
+	stats := map[string]*userStat{}
+	for _, name := range users {
+		us, err := useUser(name)
+		if err != nil {
+			return nil, err
+		}
+		ust := &userStat{Types: map[string]*stat{"total": {}}, Missing: []item{}}
+		for _, fi := range us.FIs {
+			info, err := os.Stat(fi.Path)
+			if err != nil {
+				ust.Missing = append(ust.Missing, toItem(fi))
+				continue
+			}
+			if ust.Types[fi.Type()] == nil {
+				ust.Types[fi.Type()] = &stat{}
+			}
+			for _, key := range []string{fi.Type(), "total"} {
+				ust.Types[key].Count++
+				ust.Types[key].Bytes += info.Size()
+			}
+		}
+		stats[name] = ust
+	}
+	return stats, nil
+}
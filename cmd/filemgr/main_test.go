@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	lk "github.com/digisan/logkit"
+)
+
+func TestCLI(t *testing.T) {
+
+	root := t.TempDir()
+	src := filepath.Join(root, "key.txt")
+	lk.FailOnErr("%v", os.WriteFile(src, []byte("filemgr cli test"), 0o644))
+
+	exec := func(args ...string) []byte {
+		out := &bytes.Buffer{}
+		lk.FailOnErr("%v", run(append([]string{"-root", root}, args...), out))
+		fmt.Printf("%v\n%s\n", args, out)
+		return out.Bytes()
+	}
+
+	exec("init")
+
+	fi := item{}
+	lk.FailOnErr("%v", json.Unmarshal(exec("put", "-user", "cli", "-file", src, "-note", "n0", "-groups", "g0/g1"), &fi))
+	lk.FailOnErrWhen(fi.Note != "n0" || len(fi.Groups) != 2, "%v", fmt.Errorf("put: %v", fi))
+
+	users := []string{}
+	lk.FailOnErr("%v", json.Unmarshal(exec("users"), &users))
+	lk.FailOnErrWhen(len(users) != 1 || users[0] != "cli", "%v", fmt.Errorf("users: %v", users))
+
+	lk.FailOnErrWhen(string(exec("get", "-user", "cli", "-id", fi.Id)) != "filemgr cli test", "%v", fmt.Errorf("get"))
+
+	exec("note", "-user", "cli", "-id", fi.Id, "-note", "n1")
+	exec("mv-group", "-user", "cli", "-id", fi.Id, "-index", "1", "-group", "G1")
+
+	items := []item{}
+	lk.FailOnErr("%v", json.Unmarshal(exec("ls", "-user", "cli", "-groups", "g0/G?"), &items))
+	lk.FailOnErrWhen(len(items) != 1 || items[0].Note != "n1", "%v", fmt.Errorf("ls: %v", items))
+
+	exec("export", "-user", "cli", "-o", filepath.Join(root, "export.json"))
+	exec("stats")
+	exec("check", "-user", "cli")
+
+	lk.FailOnErr("%v", os.Remove(items[0].Path))
+	stats := map[string]struct {
+		Types   map[string]struct{ Count int }
+		Missing []item
+	}{}
+	lk.FailOnErr("%v", json.Unmarshal(exec("stats"), &stats))
+	lk.FailOnErrWhen(len(stats["cli"].Missing) != 1 || stats["cli"].Types["total"].Count != 0, "%v", fmt.Errorf("stats: %v", stats))
+	exec("reconcile", "-user", "cli")
+	exec("import", "-user", "cli", "-i", filepath.Join(root, "export.json"))
+
+	items = []item{}
+	lk.FailOnErr("%v", json.Unmarshal(exec("ls", "-user", "cli"), &items))
+	lk.FailOnErrWhen(len(items) != 0, "%v", fmt.Errorf("ls: %v", items))
+
+	lk.FailOnErrWhen(run([]string{"-root", root, "nope"}, &bytes.Buffer{}) == nil, "%v", fmt.Errorf("unknown command accepted"))
+}
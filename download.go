@@ -0,0 +1,122 @@
+package filemgr
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+)
+
+// Download is a seekable view of one FileItem's content, carrying everything
+// an HTTP layer needs for Range, If-None-Match & If-Modified-Since.
+// Caller MUST Close it.
+type Download struct {
+	io.ReadSeekCloser
+	Name        string    // display name of file
+	Disposition string    // Content-Disposition value for inline display, with display name
+	ContentType string    // such as "video/mp4"
+	ModTime     time.Time // last modification of content on disk
+	Size        int64     // content length in bytes
+	ETag        string    // strong validator, quoted, from uploaded content hash & modification by processing
+}
+
+// hash of current content, refreshed by new versions. FileItems saved before metadata
+// take hash part of id, i.e. "md5-unixmilli" => "md5"
+func contentHash(fi *fdb.FileItem) (string, error) {
+	if fi.Hash() != "" {
+		return fi.Hash(), nil
+	}
+	if hash, _, ok := strings.Cut(fi.Id, "-"); ok && len(hash) == 32 {
+		return hash, nil
+	}
+	f, err := os.Open(fi.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func contentType(fi *fdb.FileItem, r io.ReadSeeker) string {
+	if ct := fi.MediaType(); ct != "" {
+		return ct
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(fi.Path)); ct != "" {
+		return ct
+	}
+	defer r.Seek(0, io.SeekStart)
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	return http.DetectContentType(head[:n])
+}
+
+// OpenFile opens the first FileItem content matching 'id' for seekable reading
+func (us *UserSpace) OpenFile(id string) (*Download, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) == 0 {
+		return nil, fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	fi := fis[0]
+	if err := us.syncMemFI(fi); err != nil {
+		return nil, err
+	}
+
+	hash, err := contentHash(fi)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fi.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Download{
+		ReadSeekCloser: f,
+		Name:           fi.Name(),
+		Disposition:    fi.ContentDisposition(true),
+		ContentType:    contentType(fi, f),
+		ModTime:        info.ModTime(),
+		Size:           info.Size(),
+		ETag:           `"` + hash + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 36) + `"`,
+	}, nil
+}
+
+// ServeFile replies to 'r' with the first FileItem content matching 'id',
+// handling Range, If-Match, If-None-Match, If-Modified-Since etc.
+func (us *UserSpace) ServeFile(w http.ResponseWriter, r *http.Request, id string) {
+	dl, err := us.OpenFile(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer dl.Close()
+
+	w.Header().Set("ETag", dl.ETag)
+	w.Header().Set("Content-Type", dl.ContentType)
+	w.Header().Set("Content-Disposition", dl.Disposition)
+	http.ServeContent(w, r, dl.Name, dl.ModTime, dl)
+}
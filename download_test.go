@@ -0,0 +1,143 @@
+package filemgr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	lk "github.com/digisan/logkit"
+)
+
+// fake mp4, 'ftyp' box header followed by random bytes
+func largeMedia(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+	copy(data, []byte{0x00, 0x00, 0x00, 0x18, 'f', 't', 'y', 'p', 'm', 'p', '4', '2'})
+	return data
+}
+
+func TestServeFile(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("download test")
+	lk.FailOnErr("%v", err)
+
+	data := largeMedia(8 << 20)
+	path, err := us.SaveFile(bytes.NewReader(data), "large.mp4", "range test", nil, true, "media")
+	lk.FailOnErr("%v", err)
+	fmt.Println("---path:", path)
+
+	fi := us.FIs[len(us.FIs)-1]
+	dl, err := us.OpenFile(fi.ID())
+	lk.FailOnErr("%v", err)
+	dl.Close()
+	fmt.Println(dl.Name, dl.ContentType, dl.Size, dl.ModTime, dl.ETag)
+	lk.FailOnErrWhen(dl.ContentType != "video/mp4", "%v", fmt.Errorf("content type: %s", dl.ContentType))
+	lk.FailOnErrWhen(dl.Size != int64(len(data)), "%v", fmt.Errorf("size: %d", dl.Size))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		us.ServeFile(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	defer srv.Close()
+
+	get := func(hdr map[string]string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/"+fi.ID(), nil)
+		lk.FailOnErr("%v", err)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		lk.FailOnErr("%v", err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		lk.FailOnErr("%v", err)
+		return resp, body
+	}
+
+	// partial content in the middle of large media
+	resp, body := get(map[string]string{"Range": "bytes=4194304-4259839"})
+	fmt.Println(resp.Status, resp.Header.Get("Content-Range"), len(body))
+	lk.FailOnErrWhen(resp.StatusCode != http.StatusPartialContent, "%v", fmt.Errorf("status: %s", resp.Status))
+	lk.FailOnErrWhen(!bytes.Equal(body, data[4194304:4259840]), "%v", fmt.Errorf("partial content mismatch"))
+
+	// open-ended range for the tail
+	resp, body = get(map[string]string{"Range": "bytes=-1024"})
+	lk.FailOnErrWhen(resp.StatusCode != http.StatusPartialContent, "%v", fmt.Errorf("status: %s", resp.Status))
+	lk.FailOnErrWhen(!bytes.Equal(body, data[len(data)-1024:]), "%v", fmt.Errorf("tail content mismatch"))
+
+	// unsatisfiable range
+	resp, _ = get(map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(data)+1)})
+	lk.FailOnErrWhen(resp.StatusCode != http.StatusRequestedRangeNotSatisfiable, "%v", fmt.Errorf("status: %s", resp.Status))
+
+	// full content with validators
+	resp, body = get(nil)
+	lk.FailOnErrWhen(resp.StatusCode != http.StatusOK, "%v", fmt.Errorf("status: %s", resp.Status))
+	lk.FailOnErrWhen(resp.Header.Get("ETag") != dl.ETag, "%v", fmt.Errorf("etag: %s", resp.Header.Get("ETag")))
+	lk.FailOnErrWhen(!bytes.Equal(body, data), "%v", fmt.Errorf("full content mismatch"))
+
+	// conditional requests
+	resp, _ = get(map[string]string{"If-None-Match": dl.ETag})
+	lk.FailOnErrWhen(resp.StatusCode != http.StatusNotModified, "%v", fmt.Errorf("status: %s", resp.Status))
+
+	resp, _ = get(map[string]string{"If-Modified-Since": dl.ModTime.Add(time.Second).UTC().Format(http.TimeFormat)})
+	lk.FailOnErrWhen(resp.StatusCode != http.StatusNotModified, "%v", fmt.Errorf("status: %s", resp.Status))
+
+	// stale If-Range falls back to full content
+	resp, body = get(map[string]string{"Range": "bytes=0-99", "If-Range": `"stale"`})
+	lk.FailOnErrWhen(resp.StatusCode != http.StatusOK || len(body) != len(data), "%v", fmt.Errorf("status: %s", resp.Status))
+
+	fmt.Println(us.DelFileItem(fi.ID()))
+}
+
+func TestFileName(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("name test")
+	lk.FailOnErr("%v", err)
+
+	// same name in same second MUST NOT collide on disk
+	path1, err := us.SaveFile(strings.NewReader("first"), "報告 final.txt", "", nil, false, "docs")
+	lk.FailOnErr("%v", err)
+	path2, err := us.SaveFile(strings.NewReader("second"), "報告 final.txt", "", nil, false, "docs")
+	lk.FailOnErr("%v", err)
+	fmt.Println(path1, path2)
+	lk.FailOnErrWhen(path1 == path2, "%v", fmt.Errorf("stored names collide: %s", path1))
+
+	fi := us.FIs[len(us.FIs)-1]
+	lk.FailOnErrWhen(fi.Name() != "報告 final.txt", "%v", fmt.Errorf("name: %s", fi.Name()))
+
+	lk.FailOnErr("%v", us.SetFIName(fi.ID(), "résumé.txt"))
+	lk.FailOnErrWhen(us.SetFIName(fi.ID(), "a/b.txt") == nil, "%v", fmt.Errorf("invalid name accepted"))
+	lk.FailOnErrWhen(fi.OriginalName() != "報告 final.txt", "%v", fmt.Errorf("original: %s", fi.OriginalName()))
+
+	// display name survives reloading
+	us, err = UseUser("name test")
+	lk.FailOnErr("%v", err)
+	fis, err := us.FileItems(fi.ID())
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(fis) != 1 || fis[0].Name() != "résumé.txt", "%v", fmt.Errorf("reloaded: %v", fis))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		us.ServeFile(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/" + fi.ID())
+	lk.FailOnErr("%v", err)
+	resp.Body.Close()
+	cd := resp.Header.Get("Content-Disposition")
+	fmt.Println(cd)
+	lk.FailOnErrWhen(cd != `inline; filename*=utf-8''r%C3%A9sum%C3%A9.txt`, "%v", fmt.Errorf("content disposition: %s", cd))
+
+	for _, fi := range us.FIs {
+		fmt.Println(us.DelFileItem(fi.ID()))
+	}
+}
@@ -0,0 +1,429 @@
+package filemgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+)
+
+const (
+	metaHead    = 1 << 20 // EXIF & XMP are read from head of file
+	maxIFDDepth = 4       // nested IFDs followed, IFD0 > ExifIFD > ...
+)
+
+// EXIF tags
+const (
+	tagMake        = 0x010F
+	tagModel       = 0x0110
+	tagOrientation = 0x0112
+	tagSoftware    = 0x0131
+	tagDateTime    = 0x0132
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+	tagDateTimeOri = 0x9003
+	tagOffsetOri   = 0x9011
+	tagMakerNote   = 0x927C
+	tagGPSLatRef   = 0x0001
+	tagGPSLat      = 0x0002
+	tagGPSLonRef   = 0x0003
+	tagGPSLon      = 0x0004
+)
+
+// location & device tags removed by 'OptStripPrivate', GPS IFD is removed entirely
+var privateTags = []uint16{
+	tagMake, tagModel, tagSoftware, tagGPSIFD, tagMakerNote,
+	0xA430, 0xA431, 0xA432, 0xA433, 0xA434, 0xA435, // owner, body serial, lens spec, make, model, serial
+}
+
+// bytes of one value of TIFF types BYTE, ASCII, SHORT, LONG, RATIONAL, UNDEFINED, SLONG, SRATIONAL
+var tiffTypeSize = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// one IFD entry of TIFF structure
+type tiffEntry struct {
+	tag, typ uint16
+	raw      []byte // 12 bytes entry
+	value    []byte // inline or pointed value, nil if invalid
+}
+
+func (e tiffEntry) str() string {
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// first SHORT or LONG value
+func (e tiffEntry) uint(bo binary.ByteOrder) int {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return int(bo.Uint16(e.value))
+	case e.typ == 4 && len(e.value) >= 4:
+		return int(bo.Uint32(e.value))
+	}
+	return 0
+}
+
+// RATIONAL values
+func (e tiffEntry) rationals(bo binary.ByteOrder) (rs []float64) {
+	if e.typ != 5 {
+		return nil
+	}
+	for i := 0; i+8 <= len(e.value); i += 8 {
+		num, den := bo.Uint32(e.value[i:]), bo.Uint32(e.value[i+4:])
+		if den == 0 {
+			return nil
+		}
+		rs = append(rs, float64(num)/float64(den))
+	}
+	return rs
+}
+
+func tiffOrder(tiff []byte) binary.ByteOrder {
+	if len(tiff) < 8 {
+		return nil
+	}
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian
+	case "MM\x00*":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// entries of IFD at offset 'ifd' of TIFF structure
+func tiffIFD(tiff []byte, bo binary.ByteOrder, ifd int) (entries []tiffEntry) {
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil
+	}
+	n := int(bo.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		pos := ifd + 2 + i*12
+		if pos+12 > len(tiff) {
+			break
+		}
+		e := tiffEntry{tag: bo.Uint16(tiff[pos:]), typ: bo.Uint16(tiff[pos+2:]), raw: tiff[pos : pos+12]}
+		size, offset := tiffTypeSize[e.typ]*int(bo.Uint32(tiff[pos+4:])), pos+8
+		if size > 4 {
+			offset = int(bo.Uint32(tiff[pos+8:]))
+		}
+		if offset+size <= len(tiff) {
+			e.value = tiff[offset : offset+size]
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// value of SHORT 'tag' in IFD0 of TIFF structure
+func tiffShort(tiff []byte, tag uint16) (uint16, bool) {
+	bo := tiffOrder(tiff)
+	if bo == nil {
+		return 0, false
+	}
+	for _, e := range tiffIFD(tiff, bo, int(bo.Uint32(tiff[4:]))) {
+		if e.tag == tag && e.typ == 3 && len(e.value) >= 2 {
+			return bo.Uint16(e.value), true
+		}
+	}
+	return 0, false
+}
+
+// TIFF structure of EXIF & XMP packet in 'data' of JPEG, PNG or HEIC-style container, nil if absent.
+// Both are sub slices of 'data'.
+func exifSources(data []byte) (tiff, xmp []byte) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}): // JPEG APP1
+		for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+			marker := data[pos+1]
+			if marker == 0xDA || marker == 0xD9 { // start of scan, no more metadata
+				break
+			}
+			end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+			if end > len(data) || end < pos+4 {
+				break
+			}
+			if seg := data[pos+4 : end]; marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) && tiff == nil {
+				tiff = seg[6:]
+			}
+			pos = end
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")): // PNG eXIf chunk
+		for pos := 8; pos+12 <= len(data); {
+			end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+			if end > len(data) || end < pos+12 {
+				break
+			}
+			if string(data[pos+4:pos+8]) == "eXIf" {
+				tiff = data[pos+8 : end-4]
+			}
+			pos = end
+		}
+	default: // such as HEIC, Exif item is stored as "Exif\0\0" & TIFF
+		for from := 0; tiff == nil; {
+			i := bytes.Index(data[from:], []byte("Exif\x00\x00"))
+			if i < 0 {
+				break
+			}
+			if from += i + 6; tiffOrder(data[from:]) != nil {
+				tiff = data[from:]
+			}
+		}
+	}
+	if tiffOrder(tiff) == nil {
+		tiff = nil
+	}
+	if i := bytes.Index(data, []byte("<x:xmpmeta")); i >= 0 {
+		if j := bytes.Index(data[i:], []byte("</x:xmpmeta>")); j >= 0 {
+			xmp = data[i : i+j+len("</x:xmpmeta>")]
+		}
+	}
+	return tiff, xmp
+}
+
+func readHead(fPath string, n int) ([]byte, error) {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, int64(n))) // NOT allocating 'n' for small file
+}
+
+// EXIF orientation 1-8 of image file, 1 if absent
+func exifOrientation(fPath string) int {
+	data, err := readHead(fPath, metaHead)
+	if err != nil {
+		return 1
+	}
+	tiff, _ := exifSources(data)
+	if o, ok := tiffShort(tiff, tagOrientation); ok && o >= 1 && o <= 8 {
+		return int(o)
+	}
+	return 1
+}
+
+// "2006:01:02 15:04:05" & "+10:00" => "2006-01-02T15:04:05+10:00", empty if invalid
+func exifTime(dt, offset string) string {
+	t, err := time.Parse("2006:01:02 15:04:05", dt)
+	if err != nil {
+		return ""
+	}
+	if off, err := time.Parse("-07:00", offset); err == nil {
+		return t.Format("2006-01-02T15:04:05") + off.Format("Z07:00")
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// degrees, minutes, seconds & reference "N", "S", "E", "W" => signed degrees
+func gpsDegrees(dms []float64, ref string) float64 {
+	deg := 0.0
+	for i, v := range dms {
+		deg += v / math.Pow(60, float64(i))
+	}
+	if ref == "S" || ref == "W" {
+		return -deg
+	}
+	return deg
+}
+
+// capture time, camera, location & orientation of EXIF 'tiff'
+func parseExif(tiff []byte, meta *fdb.Meta) {
+	bo := tiffOrder(tiff)
+	if bo == nil {
+		return
+	}
+	var dt, dtOri, offOri, latRef, lonRef string
+	var lat, lon []float64
+	for _, e := range tiffIFD(tiff, bo, int(bo.Uint32(tiff[4:]))) {
+		switch e.tag {
+		case tagMake:
+			meta.Make = e.str()
+		case tagModel:
+			meta.Model = e.str()
+		case tagOrientation:
+			meta.Orientation = e.uint(bo)
+		case tagDateTime:
+			dt = e.str()
+		case tagExifIFD:
+			for _, e := range tiffIFD(tiff, bo, e.uint(bo)) {
+				switch e.tag {
+				case tagDateTimeOri:
+					dtOri = e.str()
+				case tagOffsetOri:
+					offOri = e.str()
+				}
+			}
+		case tagGPSIFD:
+			for _, e := range tiffIFD(tiff, bo, e.uint(bo)) {
+				switch e.tag {
+				case tagGPSLatRef:
+					latRef = e.str()
+				case tagGPSLat:
+					lat = e.rationals(bo)
+				case tagGPSLonRef:
+					lonRef = e.str()
+				case tagGPSLon:
+					lon = e.rationals(bo)
+				}
+			}
+		}
+	}
+	if meta.Taken = exifTime(dtOri, offOri); meta.Taken == "" {
+		meta.Taken = exifTime(dt, offOri)
+	}
+	if len(lat) > 0 && len(lon) > 0 {
+		meta.Lat, meta.Lon, meta.GPS = gpsDegrees(lat, latRef), gpsDegrees(lon, lonRef), true
+	}
+}
+
+// value of XMP property 'name' as attribute or element
+func xmpValue(xmp []byte, name string) string {
+	r := regexp.MustCompile(regexp.QuoteMeta(name) + `(?:="([^"]*)"|>([^<]*)<)`)
+	if m := r.FindSubmatch(xmp); m != nil {
+		return strings.TrimSpace(string(m[1]) + string(m[2]))
+	}
+	return ""
+}
+
+var rXmpGPS = regexp.MustCompile(`^(\d+),(\d+(?:\.\d+)?)(?:,(\d+(?:\.\d+)?))?([NSEW])$`)
+
+// XMP "37,46.5N" or "37,46,30N" => signed degrees
+func xmpDegrees(v string) (float64, bool) {
+	m := rXmpGPS.FindStringSubmatch(v)
+	if m == nil {
+		return 0, false
+	}
+	dms := []float64{}
+	for _, s := range m[1:4] {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			dms = append(dms, f)
+		}
+	}
+	return gpsDegrees(dms, m[4]), true
+}
+
+// fill what EXIF misses from XMP packet
+func parseXmp(xmp []byte, meta *fdb.Meta) {
+	if meta.Taken == "" {
+		for _, name := range []string{"exif:DateTimeOriginal", "xmp:CreateDate", "photoshop:DateCreated"} {
+			if v := xmpValue(xmp, name); v != "" {
+				meta.Taken = v
+				break
+			}
+		}
+	}
+	if meta.Make == "" {
+		meta.Make = xmpValue(xmp, "tiff:Make")
+	}
+	if meta.Model == "" {
+		meta.Model = xmpValue(xmp, "tiff:Model")
+	}
+	if !meta.GPS {
+		lat, okLat := xmpDegrees(xmpValue(xmp, "exif:GPSLatitude"))
+		lon, okLon := xmpDegrees(xmpValue(xmp, "exif:GPSLongitude"))
+		if okLat && okLon {
+			meta.Lat, meta.Lon, meta.GPS = lat, lon, true
+		}
+	}
+}
+
+// EXIF & XMP of image file into 'meta'
+func extractExif(fPath string, meta *fdb.Meta) error {
+	data, err := readHead(fPath, metaHead)
+	if err != nil {
+		return err
+	}
+	tiff, xmp := exifSources(data)
+	if tiff != nil {
+		parseExif(tiff, meta)
+	}
+	if xmp != nil {
+		parseXmp(xmp, meta)
+	}
+	return nil
+}
+
+// remove 'privateTags' from TIFF structure in place, their values are zeroed. return if anything is removed
+func stripTiff(tiff []byte) bool {
+	bo := tiffOrder(tiff)
+	if bo == nil {
+		return false
+	}
+	stripped, visited := false, map[int]bool{}
+	var strip func(ifd int, all bool, depth int)
+	strip = func(ifd int, all bool, depth int) {
+		if visited[ifd] || depth > maxIFDDepth { // crafted offsets may loop
+			return
+		}
+		visited[ifd] = true
+		entries := tiffIFD(tiff, bo, ifd)
+		keep := [][]byte{}
+		for _, e := range entries {
+			switch {
+			case e.tag == tagExifIFD && !all:
+				strip(e.uint(bo), false, depth+1)
+				keep = append(keep, append([]byte{}, e.raw...))
+			case all || In(e.tag, privateTags...):
+				if e.tag == tagGPSIFD {
+					strip(e.uint(bo), true, depth+1)
+				}
+				clear(e.value)
+				stripped = true
+			default:
+				keep = append(keep, append([]byte{}, e.raw...))
+			}
+		}
+		if len(keep) == len(entries) {
+			return
+		}
+		bo.PutUint16(tiff[ifd:], uint16(len(keep)))
+		for i, e := range entries {
+			if i < len(keep) {
+				copy(e.raw, keep[i])
+			} else {
+				clear(e.raw)
+			}
+		}
+	}
+	strip(int(bo.Uint32(tiff[4:])), false, 0)
+	return stripped
+}
+
+// strip location & device tags of EXIF, and XMP packet of image file in place.
+// Container layout is kept, so JPEG, PNG & HEIC-style files all stay valid.
+func stripPrivate(fPath string) error {
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		return err
+	}
+	tiff, xmp := exifSources(data)
+	stripped := tiff != nil && stripTiff(tiff)
+	if xmp != nil {
+		for i := range xmp {
+			xmp[i] = ' ' // XMP packet allows padding
+		}
+		stripped = true
+	}
+	if !stripped {
+		return nil
+	}
+	if bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) { // chunk CRCs
+		for pos := 8; pos+12 <= len(data); {
+			end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+			if end > len(data) || end < pos+12 {
+				break
+			}
+			binary.BigEndian.PutUint32(data[end-4:], crc32.ChecksumIEEE(data[pos+4:end-4]))
+			pos = end
+		}
+	}
+	return os.WriteFile(fPath, data, 0o644)
+}
@@ -0,0 +1,160 @@
+package filemgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/digisan/file-mgr/fdb"
+	lk "github.com/digisan/logkit"
+)
+
+type testTag struct {
+	tag, typ uint16
+	value    []byte // SHORT, LONG, ASCII or RATIONAL in big endian
+	sub      []testTag
+}
+
+func rational(vs ...uint32) []byte {
+	b := []byte{}
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, v)
+		b = binary.BigEndian.AppendUint32(b, 1)
+	}
+	return b
+}
+
+// big endian TIFF with IFD0 'tags', sub IFDs are pointed by LONG
+func testTIFF(tags []testTag) []byte {
+	tiff := []byte("MM\x00*\x00\x00\x00\x08")
+	var ifd func(tags []testTag) int
+	ifd = func(tags []testTag) int {
+		at := len(tiff)
+		tiff = binary.BigEndian.AppendUint16(tiff, uint16(len(tags)))
+		tiff = append(tiff, make([]byte, 12*len(tags)+4)...)
+		for i, t := range tags {
+			pos := at + 2 + i*12
+			value, count := t.value, len(t.value)/tiffTypeSize[t.typ]
+			if t.sub != nil {
+				value = binary.BigEndian.AppendUint32(nil, uint32(ifd(t.sub)))
+				count = 1
+			}
+			binary.BigEndian.PutUint16(tiff[pos:], t.tag)
+			binary.BigEndian.PutUint16(tiff[pos+2:], t.typ)
+			binary.BigEndian.PutUint32(tiff[pos+4:], uint32(count))
+			if len(value) <= 4 {
+				copy(tiff[pos+8:], value)
+			} else {
+				binary.BigEndian.PutUint32(tiff[pos+8:], uint32(len(tiff)))
+				tiff = append(tiff, value...)
+			}
+		}
+		return at
+	}
+	ifd(tags)
+	return tiff
+}
+
+func testPhoto() *bytes.Buffer {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	img.Set(0, 0, color.Black)
+	buf := &bytes.Buffer{}
+	lk.FailOnErr("%v", jpeg.Encode(buf, img, nil))
+
+	tiff := testTIFF([]testTag{
+		{tag: tagMake, typ: 2, value: []byte("Canon\x00")},
+		{tag: tagModel, typ: 2, value: []byte("Canon EOS R5\x00")},
+		{tag: tagOrientation, typ: 3, value: []byte{0, 1}},
+		{tag: tagExifIFD, typ: 4, sub: []testTag{
+			{tag: tagDateTimeOri, typ: 2, value: []byte("2023:05:01 10:20:30\x00")},
+			{tag: tagOffsetOri, typ: 2, value: []byte("+10:00\x00")},
+			{tag: 0xA431, typ: 2, value: []byte("SERIAL123456\x00")},
+		}},
+		{tag: tagGPSIFD, typ: 4, sub: []testTag{
+			{tag: tagGPSLatRef, typ: 2, value: []byte("S\x00")},
+			{tag: tagGPSLat, typ: 5, value: rational(33, 52, 12)},
+			{tag: tagGPSLonRef, typ: 2, value: []byte("E\x00")},
+			{tag: tagGPSLon, typ: 5, value: rational(151, 12, 36)},
+		}},
+	})
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:Description tiff:Make="Canon" exif:GPSLatitude="33,52.2S"/></x:xmpmeta>`)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	app1x := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)
+	segs := []byte{}
+	for _, app := range [][]byte{app1, app1x} {
+		segs = append(segs, 0xFF, 0xE1, byte((len(app)+2)>>8), byte(len(app)+2))
+		segs = append(segs, app...)
+	}
+	data := buf.Bytes()
+	return bytes.NewBuffer(append(append(append([]byte{}, data[:2]...), segs...), data[2:]...))
+}
+
+func TestExif(t *testing.T) {
+
+	InitFileMgr("./data")
+	OptThumbOnSave(false)
+	defer OptThumbOnSave(true)
+
+	us, err := UseUser("exif test")
+	lk.FailOnErr("%v", err)
+
+	_, err = us.SaveFile(testPhoto(), "trip.jpg", "", nil, false, "album")
+	lk.FailOnErr("%v", err)
+	fi := us.FIs[len(us.FIs)-1]
+	fmt.Println(fi.Meta)
+	lat, lon, ok := fi.Location()
+	lk.FailOnErrWhen(!ok || lat > -33.86 || lat < -33.88 || lon < 151.20 || lon > 151.22, "%v", fmt.Errorf("location %v %v", lat, lon))
+	lk.FailOnErrWhen(fi.Camera() != "Canon EOS R5", "%v", fmt.Errorf("camera [%s]", fi.Camera()))
+	lk.FailOnErrWhen(fi.Taken().UTC().Format("2006-01-02 15:04") != "2023-05-01 00:20", "%v", fmt.Errorf("taken %v", fi.Taken()))
+
+	// privacy mode
+	OptStripPrivate(true)
+	defer OptStripPrivate(false)
+
+	_, err = us.SaveFile(testPhoto(), "shared.jpg", "", nil, false, "album")
+	lk.FailOnErr("%v", err)
+	priv := us.FIs[len(us.FIs)-1]
+	fmt.Println(priv.Meta)
+	_, _, ok = priv.Location()
+	lk.FailOnErrWhen(ok || priv.Camera() != "", "%v", fmt.Errorf("private tags remain %v", priv.Meta))
+	lk.FailOnErrWhen(!priv.Taken().Equal(fi.Taken()) || priv.Meta.Orientation != 1, "%v", fmt.Errorf("capture time & orientation MUST be kept %v", priv.Meta))
+
+	data, err := os.ReadFile(priv.Path)
+	lk.FailOnErr("%v", err)
+	for _, s := range []string{"Canon", "SERIAL", "GPSLatitude"} {
+		lk.FailOnErrWhen(bytes.Contains(data, []byte(s)), "%v", fmt.Errorf("[%s] remains in stored file", s))
+	}
+	_, err = jpeg.Decode(bytes.NewReader(data))
+	lk.FailOnErr("stripped jpeg: %v", err)
+}
+
+// crafted EXIF MUST NOT crash parsing or stripping
+func FuzzExif(f *testing.F) {
+	loop := []byte("MM\x00*\x00\x00\x00\x08\x00\x01\x87\x69\x00\x04\x00\x00\x00\x01\x00\x00\x00\x08\x00\x00\x00\x00") // ExifIFD points back at IFD0
+	app1 := append([]byte("Exif\x00\x00"), loop...)
+	f.Add(testPhoto().Bytes())
+	f.Add(loop)
+	f.Add(append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0, byte(len(app1) + 2)}, app1...))
+	f.Add(append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x1aeXIf"), append(loop, 0, 0, 0, 0)...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tiff, _ := exifSources(data)
+		if tiff == nil {
+			tiff = data
+		}
+		if bo := tiffOrder(tiff); bo != nil {
+			for _, e := range tiffIFD(tiff, bo, int(bo.Uint32(tiff[4:]))) {
+				tiffIFD(tiff, bo, e.uint(bo))
+			}
+			parseExif(tiff, &fdb.Meta{})
+		}
+		stripTiff(tiff)
+	})
+}
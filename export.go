@@ -0,0 +1,212 @@
+package filemgr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+)
+
+const (
+	FmtZip   = "zip"
+	FmtTarGz = "tar.gz"
+
+	ManifestName = "manifest.json" // first entry of an exported archive
+)
+
+// ExportFilter selects FileItems to export, zero value selects all
+type ExportFilter struct {
+	Groups []string  // leading groups, wildcards '*' & '?' as 'SearchFileItem'
+	Types  []string  // file types, such as "image", "video"
+	From   time.Time // FileItem time from, inclusive
+	To     time.Time // FileItem time to, exclusive
+}
+
+// regexp of group pattern 'grp', wildcards '*' & '?' as 'SearchFileItem', other characters are literal
+func groupPattern(grp string) (*regexp.Regexp, error) {
+	ltr := regexp.QuoteMeta(grp)
+	ltr = strings.ReplaceAll(ltr, `\*`, `[\d\w\s]*`)
+	ltr = strings.ReplaceAll(ltr, `\?`, `[\d\w\s]?`)
+	return regexp.Compile(`^` + ltr + `$`)
+}
+
+// FileItem matcher of 'flt'
+func (flt *ExportFilter) matcher() (func(fi *fdb.FileItem) bool, error) {
+	if flt == nil {
+		return func(fi *fdb.FileItem) bool { return true }, nil
+	}
+	regs := []*regexp.Regexp{}
+	for _, grp := range flt.Groups {
+		reg, err := groupPattern(grp)
+		if err != nil {
+			return nil, fmt.Errorf("group pattern [%s]: %w", grp, err)
+		}
+		regs = append(regs, reg)
+	}
+	return func(fi *fdb.FileItem) bool {
+		if len(flt.Types) > 0 && NotIn(fi.Type(), flt.Types...) {
+			return false
+		}
+		if !flt.From.IsZero() && fi.Tm.Before(flt.From) {
+			return false
+		}
+		if !flt.To.IsZero() && !fi.Tm.Before(flt.To) {
+			return false
+		}
+		groupList := strings.Split(fi.GroupList, fdb.SEP_GRP)
+		if len(regs) > len(groupList) {
+			return false
+		}
+		for i, reg := range regs {
+			if !reg.MatchString(groupList[i]) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// ManifestItem describes one exported FileItem
+type ManifestItem struct {
+	Id     string    `json:"id"`
+	Name   string    `json:"name"`     // display name
+	Origin string    `json:"original"` // original file name when saved
+	File   string    `json:"file"`     // entry path in archive, "month/group.../type/file"
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Groups []string  `json:"groups"`
+	Note   string    `json:"note"`
+	Hash   string    `json:"hash"` // md5 of content
+}
+
+type Manifest struct {
+	User     string         `json:"user"`
+	Exported time.Time      `json:"exported"`
+	Items    []ManifestItem `json:"items"`
+}
+
+func (us *UserSpace) manifestItem(fi *fdb.FileItem) (ManifestItem, error) {
+	hash, err := contentHash(fi)
+	if err != nil {
+		return ManifestItem{}, err
+	}
+	groups := []string{}
+	if fi.GroupList != "" {
+		groups = strings.Split(fi.GroupList, fdb.SEP_GRP)
+	}
+	return ManifestItem{
+		Id:     fi.ID(),
+		Name:   fi.Name(),
+		Origin: fi.OriginalName(),
+		File:   filepath.ToSlash(strings.TrimPrefix(fi.Path, us.UserPath)),
+		Type:   fi.Type(),
+		Time:   fi.Tm,
+		Groups: groups,
+		Note:   fi.Note,
+		Hash:   hash,
+	}, nil
+}
+
+// Export streams selected FileItems in their group hierarchy, led by a JSON manifest, to 'w' as 'format' archive.
+// 'format' is FmtZip or FmtTarGz, 'flt' nil exports all.
+func (us *UserSpace) Export(w io.Writer, format string, flt *ExportFilter) (*Manifest, error) {
+	match, err := flt.matcher()
+	if err != nil {
+		return nil, err
+	}
+	mf := &Manifest{User: us.UName, Exported: time.Now(), Items: []ManifestItem{}}
+	fis := []*fdb.FileItem{}
+	for _, fi := range us.FIs {
+		if us.Own(fi) && match(fi) {
+			item, err := us.manifestItem(fi)
+			if err != nil {
+				return nil, err
+			}
+			mf.Items = append(mf.Items, item)
+			fis = append(fis, fi)
+		}
+	}
+	mfData, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FmtZip:
+		return mf, exportZip(w, mfData, mf, fis)
+	case FmtTarGz:
+		return mf, exportTarGz(w, mfData, mf, fis)
+	default:
+		return nil, fmt.Errorf("export format [%s] is unsupported, only [%s %s]", format, FmtZip, FmtTarGz)
+	}
+}
+
+func exportZip(w io.Writer, mfData []byte, mf *Manifest, fis []*fdb.FileItem) error {
+	zw := zip.NewWriter(w)
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: ManifestName, Method: zip.Deflate, Modified: mf.Exported})
+	if err != nil {
+		return err
+	}
+	if _, err := entry.Write(mfData); err != nil {
+		return err
+	}
+	for i, fi := range fis {
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: mf.Items[i].File, Method: zip.Deflate, Modified: fi.Tm})
+		if err != nil {
+			return err
+		}
+		if err := copyFile(entry, fi.Path); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func exportTarGz(w io.Writer, mfData []byte, mf *Manifest, fis []*fdb.FileItem) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	hdr := &tar.Header{Name: ManifestName, Mode: 0o644, Size: int64(len(mfData)), ModTime: mf.Exported}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(mfData); err != nil {
+		return err
+	}
+	for i, fi := range fis {
+		info, err := os.Stat(fi.Path)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: mf.Items[i].File, Mode: 0o644, Size: info.Size(), ModTime: fi.Tm}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := copyFile(tw, fi.Path); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,69 @@
+package filemgr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	lk "github.com/digisan/logkit"
+)
+
+func TestExport(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("export test")
+	lk.FailOnErr("%v", err)
+
+	for i, grps := range [][]string{{"G0", "G1"}, {"G0", "G2"}, {"G3"}} {
+		_, err := us.SaveFile(strings.NewReader(fmt.Sprintf("content %d", i)), fmt.Sprintf("f%d.txt", i), fmt.Sprintf("note %d", i), nil, true, grps...)
+		lk.FailOnErr("%v", err)
+	}
+
+	// zip, filtered by group & time
+	buf := &bytes.Buffer{}
+	flt := &ExportFilter{Groups: []string{"G0", "G?"}, From: time.Now().Add(-time.Minute)}
+	mf, err := us.Export(buf, FmtZip, flt)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(mf.Items) < 2, "%v", fmt.Errorf("items: %d", len(mf.Items)))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(zr.File[0].Name != ManifestName || len(zr.File) != len(mf.Items)+1, "%v", fmt.Errorf("zip entries: %d", len(zr.File)))
+	for _, f := range zr.File {
+		fmt.Println(f.Name, f.Modified)
+	}
+
+	// regexp characters in groups are literal
+	for _, grp := range []string{"G(", "[G0", `G\`, "G0|G3"} {
+		mf, err := us.Export(io.Discard, FmtZip, &ExportFilter{Groups: []string{grp}})
+		lk.FailOnErr("%v", err)
+		lk.FailOnErrWhen(len(mf.Items) != 0, "%v", fmt.Errorf("[%s] matched %d items", grp, len(mf.Items)))
+	}
+
+	// tar.gz, filtered by type
+	buf.Reset()
+	_, err = us.Export(buf, FmtTarGz, &ExportFilter{Types: []string{"video"}})
+	lk.FailOnErr("%v", err)
+	gr, err := gzip.NewReader(buf)
+	lk.FailOnErr("%v", err)
+	tr := tar.NewReader(gr)
+	hdr, err := tr.Next()
+	lk.FailOnErr("%v", err)
+	mfTar := &Manifest{}
+	lk.FailOnErr("%v", json.NewDecoder(tr).Decode(mfTar))
+	fmt.Println(hdr.Name, mfTar.User, len(mfTar.Items))
+	lk.FailOnErrWhen(len(mfTar.Items) != 0, "%v", fmt.Errorf("video items: %d", len(mfTar.Items)))
+	_, err = tr.Next()
+	lk.FailOnErrWhen(err != io.EOF, "%v", fmt.Errorf("unexpected tar entry"))
+
+	_, err = us.Export(io.Discard, "rar", nil)
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("rar accepted"))
+}
@@ -1,7 +1,9 @@
 package fdb
 
 import (
+	"errors"
 	"sync"
+	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
 	lk "github.com/digisan/logkit"
@@ -10,6 +12,7 @@ import (
 type DBGrp struct {
 	sync.Mutex
 	File *badger.DB
+	Job  *badger.DB
 }
 
 var (
@@ -28,14 +31,33 @@ func open(dir string) *badger.DB {
 	return db
 }
 
+// jobs in a separate DB, FileItem DB keys are all FileItems
+func jobDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	return dir + "-job"
+}
+
 func InitDB(dir string) *DBGrp {
 	if DbGrp == nil {
 		once.Do(func() {
 			DbGrp = &DBGrp{
 				File: open(dir),
+				Job:  open(jobDir(dir)),
 			}
 		})
 	}
+
+	// reopen after 'CloseDB'
+	DbGrp.Lock()
+	defer DbGrp.Unlock()
+	if DbGrp.File == nil {
+		DbGrp.File = open(dir)
+	}
+	if DbGrp.Job == nil {
+		DbGrp.Job = open(jobDir(dir))
+	}
 	return DbGrp
 }
 
@@ -47,4 +69,37 @@ func CloseDB() {
 		lk.FailOnErr("%v", DbGrp.File.Close())
 		DbGrp.File = nil
 	}
+	if DbGrp.Job != nil {
+		lk.FailOnErr("%v", DbGrp.Job.Close())
+		DbGrp.Job = nil
+	}
+	dueAt = time.Time{}
+}
+
+// run 'ops' on 'db' in as few transactions as badger allows, a full one is committed before the next starts.
+// return number of 'ops' committed
+func writeBatches(db *badger.DB, ops []func(txn *badger.Txn) error) (n int, err error) {
+	txn := db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+
+	pending := 0
+	for _, op := range ops {
+		err := op(txn)
+		if errors.Is(err, badger.ErrTxnTooBig) && pending > 0 {
+			if err := txn.Commit(); err != nil {
+				return n, err
+			}
+			n, pending = n+pending, 0
+			txn = db.NewTransaction(true)
+			err = op(txn)
+		}
+		if err != nil {
+			return n, err
+		}
+		pending++
+	}
+	if err := txn.Commit(); err != nil {
+		return n, err
+	}
+	return n + pending, nil
 }
@@ -2,6 +2,7 @@ package fdb
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -50,3 +51,28 @@ func TestListFile(t *testing.T) {
 		fmt.Println(fi)
 	}
 }
+
+func TestReplaceMany(t *testing.T) {
+	InitDB("")
+	defer CloseDB()
+
+	// too many for one badger transaction, one time as binary time of some may contain SEP
+	tm := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	olds, news := []*FileItem{}, []*FileItem{}
+	for i := 0; i < 100000; i++ {
+		fi := &FileItem{Id: fmt.Sprintf("many-%06d", i), Path: fmt.Sprintf("root/old/G0/text/f%d.txt", i), Tm: tm, Note: "note of many"}
+		nfi := *fi
+		nfi.Path = fmt.Sprintf("root/new/G0/text/f%d.txt", i)
+		olds, news = append(olds, fi), append(news, &nfi)
+	}
+	lk.FailOnErr("%v", ReplaceFileItems(nil, olds))
+	lk.FailOnErr("%v", ReplaceFileItems(olds, news))
+	fis, err := ListFileItems(func(fi *FileItem) bool { return strings.HasPrefix(fi.Id, "many-") })
+	lk.FailOnErr("%v", err)
+	for _, fi := range fis {
+		lk.FailOnErrWhen(!strings.HasPrefix(fi.Path, "root/new/"), "%v", fmt.Errorf("NOT replaced: %v", fi))
+	}
+	lk.FailOnErrWhen(len(fis) != len(news), "%v", fmt.Errorf("%d replaced", len(fis)))
+	lk.FailOnErr("%v", DeleteFileItems(news))
+	lk.FailOnErrWhen(IsExisting("many-000000"), "%v", fmt.Errorf("NOT deleted"))
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"mime"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -22,15 +23,25 @@ const (
 	SEP_GRP = "^"
 )
 
+// '^' & '%' in string values are escaped, so free text such as note never holds SEP
+var (
+	escVal   = strings.NewReplacer("%", "%25", "^", "%5E")
+	unescVal = strings.NewReplacer("%25", "%", "%5E", "^")
+)
+
 type FileItem struct {
 	// key
 	Id string `json:"id"` // id, for linkage
 	// value
 	Path      string `json:"path"` // file path on real local disk
 	prevPath  string
-	Tm        time.Time `json:"time"`   // timestamp
-	GroupList string    `json:"groups"` // "group1^group2^...^groupN", [once changed, => change Path, => move file]
-	Note      string    `json:"note"`   // "note..."
+	Tm        time.Time `json:"time"`     // timestamp
+	GroupList string    `json:"groups"`   // "group1^group2^...^groupN", [once changed, => change Path, => move file]
+	Note      string    `json:"note"`     // "note..."
+	OriName   string    `json:"original"` // original file name when saved
+	DispName  string    `json:"name"`     // display name, renamable without moving file
+	SrcId     string    `json:"source"`   // id of source FileItem if derived from it, such as a converted image
+	Meta      Meta      `json:"meta"`     // content metadata, such as size, dimensions, duration
 }
 
 func (fi FileItem) String() string {
@@ -68,6 +79,10 @@ const (
 	VO_Tm
 	VO_GroupList
 	VO_Note
+	VO_OriName
+	VO_DispName
+	VO_SrcId
+	VO_Meta
 	VO_END
 )
 
@@ -77,6 +92,10 @@ func (fi *FileItem) ValFieldAddr(mov int) any {
 		VO_Tm:        &fi.Tm,
 		VO_GroupList: &fi.GroupList,
 		VO_Note:      &fi.Note,
+		VO_OriName:   &fi.OriName,
+		VO_DispName:  &fi.DispName,
+		VO_SrcId:     &fi.SrcId,
+		VO_Meta:      &fi.Meta,
 	}
 	return mFldAddr[mov]
 }
@@ -115,11 +134,11 @@ func (fi *FileItem) Value() []byte {
 		}
 		switch v := fi.ValFieldAddr(i).(type) {
 		case *string:
-			sb.WriteString(*v)
+			sb.WriteString(escVal.Replace(*v))
 		case *time.Time:
-			encoding, err := (*v).MarshalBinary()
-			lk.FailOnErr("%v", err)
-			sb.Write(encoding)
+			sb.WriteString(v.Format(time.RFC3339Nano)) // text, binary time may contain SEP
+		case *Meta:
+			sb.Write(v.encode())
 		default:
 			panic("need more type for marshaling value")
 		}
@@ -147,10 +166,21 @@ func (fi *FileItem) Unmarshal(dbKey, dbVal []byte) (any, error) {
 			switch v := param.fnFldAddr(i).(type) {
 			case *string:
 				*v = string(seg)
+				if idx == 1 {
+					*v = unescVal.Replace(*v)
+				}
 			case *time.Time:
-				t := &time.Time{}
-				lk.FailOnErr("%v @ %v", t.UnmarshalBinary(seg), seg)
-				*v = *t
+				t, err := time.Parse(time.RFC3339Nano, string(seg))
+				if err != nil {
+					// stored as binary before
+					t = time.Time{}
+					lk.FailOnErr("%v @ %v", t.UnmarshalBinary(seg), seg)
+				}
+				*v = t
+			case *Meta:
+				if err := v.decode(seg); err != nil {
+					return nil, err
+				}
 			default:
 				panic("Unmarshal Error Type")
 			}
@@ -169,24 +199,57 @@ func (fi *FileItem) Type() string {
 	dir := filepath.Dir(fi.Path)
 	typeDir := filepath.Base(dir)
 	if !fd.IsSupportedFileType(typeDir) {
-		return "file type is unregistered"
+		return fd.Unknown
 	}
 	return typeDir
 }
 
+// display name, falls back to original name, then stored name
 func (fi *FileItem) Name() string {
+	switch {
+	case fi.DispName != "":
+		return fi.DispName
+	case fi.OriName != "":
+		return fi.OriName
+	default:
+		return fi.StoredName()
+	}
+}
+
+// original file name when saved, falls back to stored name
+func (fi *FileItem) OriginalName() string {
+	if fi.OriName != "" {
+		return fi.OriName
+	}
+	return fi.StoredName()
+}
+
+// file name on real local disk
+func (fi *FileItem) StoredName() string {
 	return filepath.Base(fi.Path)
 }
 
+// Content-Disposition header value with display name, non-ASCII name is encoded as RFC 2231
+func (fi *FileItem) ContentDisposition(inline bool) string {
+	disp := "attachment"
+	if inline {
+		disp = "inline"
+	}
+	if v := mime.FormatMediaType(disp, map[string]string{"filename": fi.Name()}); v != "" {
+		return v
+	}
+	return disp
+}
+
 // type value as `<video><source src="movie.mp4" type="video/mp4"> ...`
 func (fi *FileItem) MediaType() string {
-	ext := strings.TrimSuffix(filepath.Ext(fi.Path), ".")
+	ext := strings.TrimPrefix(filepath.Ext(fi.Path), ".")
 	switch fi.Type() {
-	case "photo":
+	case "photo", fd.Image:
 		return "image/" + ext // apng gif ico cur jpg jpeg jfif pjpeg pjp png svg
-	case "audio":
+	case fd.Audio:
 		return "audio/" + ext // mid midi rm ram wma aac wav ogg mp3 mp4
-	case "video":
+	case fd.Video:
 		return "video/" + ext // mpg mpeg avi wmv mov rm ram swf flv ogg webm mp4
 	default:
 		return ""
@@ -198,6 +261,15 @@ func (fi *FileItem) SetNote(note string) {
 	fi.Note = note
 }
 
+// Need updating DB immediately, stored file is NOT moved
+func (fi *FileItem) SetName(name string) error {
+	if name = strings.TrimSpace(name); name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, SEP) {
+		return fmt.Errorf("invalid file name [%s]", name)
+	}
+	fi.DispName = name
+	return nil
+}
+
 // Need updating DB immediately
 func (fi *FileItem) SetGroup(grpIdx int, grpName string) (string, error) {
 	oldGrpPath := strings.ReplaceAll(fi.GroupList, SEP_GRP, PS)
@@ -264,6 +336,59 @@ func UpdateFileItem(fi *FileItem) error {
 	return bh.UpsertOneObject(fi)
 }
 
+// EditFileItem applies 'edit' to FileItem of exactly 'id' as stored, then stores it, under one lock.
+// Fields 'edit' leaves alone keep stored values, such as Path & Meta updated by a background job meanwhile.
+func EditFileItem(id string, edit func(fi *FileItem) error) (*FileItem, error) {
+	DbGrp.Lock()
+	defer DbGrp.Unlock()
+
+	fi, err := bh.GetFirstObject[FileItem]([]byte(id), func(fi *FileItem) bool { return fi.Id == id })
+	if err != nil {
+		return nil, err
+	}
+	if fi == nil || fi.Path == "" {
+		return nil, fmt.Errorf("file item [%s] %w", id, os.ErrNotExist)
+	}
+	if err := edit(fi); err != nil {
+		return nil, err
+	}
+	fi.prevPath = ""
+	return fi, bh.UpsertOneObject(fi)
+}
+
+// replace 'olds' with 'news', in several transactions if too many for one. Either all or none is applied,
+// as committed part is rolled back on failure. 'olds' can be nil
+func ReplaceFileItems(olds, news []*FileItem) error {
+	DbGrp.Lock()
+	defer DbGrp.Unlock()
+
+	n, err := replaceBatches(olds, news)
+	if err != nil && n > 0 {
+		nOld := min(n, len(olds))
+		if _, errBack := replaceBatches(news[:n-nOld], olds[:nOld]); errBack != nil {
+			return fmt.Errorf("%w, and rolling back failed: %v", err, errBack)
+		}
+	}
+	return err
+}
+
+// delete 'olds' then set 'news', return number of them committed
+func replaceBatches(olds, news []*FileItem) (int, error) {
+	ops := make([]func(txn *badger.Txn) error, 0, len(olds)+len(news))
+	for _, fi := range olds {
+		ops = append(ops, func(txn *badger.Txn) error { return txn.Delete(fi.Key()) })
+	}
+	for _, fi := range news {
+		ops = append(ops, func(txn *badger.Txn) error { return txn.Set(fi.Marshal(nil)) })
+	}
+	return writeBatches(DbGrp.File, ops)
+}
+
+// remove exactly 'fis', either all or none is removed
+func DeleteFileItems(fis []*FileItem) error {
+	return ReplaceFileItems(fis, nil)
+}
+
 func FirstFileItem(id string) (*FileItem, bool, error) {
 	DbGrp.Lock()
 	defer DbGrp.Unlock()
@@ -1,9 +1,12 @@
 package fdb
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 	"time"
+
+	fd "github.com/digisan/gotk/file-dir"
 )
 
 func TestFileItem(t *testing.T) {
@@ -16,3 +19,51 @@ func TestFileItem(t *testing.T) {
 	fi.Unmarshal(dbKey, dbVal)
 	fmt.Println(fi)
 }
+
+func TestFileItemRoundTrip(t *testing.T) {
+	fi := FileItem{
+		Id:        "id",
+		Path:      "a/b/100%^^.txt",
+		Tm:        time.Now(),
+		GroupList: "g0^g1",
+		Note:      "note with ^^ inside %5E",
+		OriName:   "ori^^name.txt",
+		DispName:  "disp^name%25.txt",
+		SrcId:     "src",
+		Meta:      Meta{Size: 12, Hash: "hash"},
+	}
+	got := &FileItem{}
+	if _, err := got.Unmarshal(fi.Marshal(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Tm.Equal(fi.Tm) {
+		t.Errorf("time is [%v], expected [%v]", got.Tm, fi.Tm)
+	}
+	got.Tm = fi.Tm
+	if *got != fi {
+		t.Errorf("reloaded as %v, expected %v", got, fi)
+	}
+
+	// binary time stored before is still read
+	fi.Tm = time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	tm, err := fi.Tm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := bytes.Replace(fi.Value(), []byte(fi.Tm.Format(time.RFC3339Nano)), tm, 1)
+	if _, err := got.Unmarshal(fi.Key(), val); err != nil || !got.Tm.Equal(fi.Tm) {
+		t.Errorf("binary time reloaded as [%v], %v", got.Tm, err)
+	}
+}
+
+func TestFileItemType(t *testing.T) {
+	for path, want := range map[string]string{
+		"root/name/image/a.png":   fd.Image,
+		"root/name/unknown/a.bin": fd.Unknown,
+		"root/name/bogus/a.bin":   fd.Unknown,
+	} {
+		if got := (&FileItem{Path: path}).Type(); got != want {
+			t.Errorf("type of [%s] is [%s], expected [%s]", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package fdb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	bh "github.com/digisan/db-helper/badger"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a background task on one FileItem, stored in its own DB
+type Job struct {
+	// key
+	Id string `json:"id"` // zero padded unix nano of creation, jobs run in key order
+	// value
+	User      string    `json:"user"`     // owner of FileItem
+	FiId      string    `json:"fileitem"` // FileItem id
+	Kind      string    `json:"kind"`     // such as "process", "thumbnail"
+	Args      string    `json:"args"`     // JSON arguments of 'Kind'
+	Status    string    `json:"status"`   // JobPending, JobRunning, JobDone, JobFailed
+	Tries     int       `json:"tries"`    // number of finished runs
+	Progress  int       `json:"progress"` // percentage 0-100 of running or finished run
+	Err       string    `json:"error"`    // error of last run
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+	NotBefore time.Time `json:"not_before"` // pending job waits until, for retrying later
+}
+
+func (job Job) String() string {
+	sb := strings.Builder{}
+	typ := reflect.TypeOf(job)
+	val := reflect.ValueOf(job)
+	sb.WriteString("{\n")
+	for i := 0; i < typ.NumField(); i++ {
+		fld, val := typ.Field(i), val.Field(i)
+		sb.WriteString(fmt.Sprintf("\t%-12s %v\n", fld.Name+":", val))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// db key order
+const (
+	JKO_Id int = iota
+	JKO_END
+)
+
+func (job *Job) KeyFieldAddr(mok int) any {
+	mFldAddr := map[int]any{
+		JKO_Id: &job.Id,
+	}
+	return mFldAddr[mok]
+}
+
+// db value order
+const (
+	JVO_User int = iota
+	JVO_FiId
+	JVO_Kind
+	JVO_Args
+	JVO_Status
+	JVO_Tries
+	JVO_Progress
+	JVO_Err
+	JVO_Created
+	JVO_Updated
+	JVO_NotBefore
+	JVO_END
+)
+
+func (job *Job) ValFieldAddr(mov int) any {
+	mFldAddr := map[int]any{
+		JVO_User:      &job.User,
+		JVO_FiId:      &job.FiId,
+		JVO_Kind:      &job.Kind,
+		JVO_Args:      &job.Args,
+		JVO_Status:    &job.Status,
+		JVO_Tries:     &job.Tries,
+		JVO_Progress:  &job.Progress,
+		JVO_Err:       &job.Err,
+		JVO_Created:   &job.Created,
+		JVO_Updated:   &job.Updated,
+		JVO_NotBefore: &job.NotBefore,
+	}
+	return mFldAddr[mov]
+}
+
+///////////////////////////////////////////////////
+
+func (job *Job) BadgerDB() *badger.DB {
+	return DbGrp.Job
+}
+
+func (job *Job) Key() []byte {
+	return []byte(job.Id)
+}
+
+func (job *Job) Value() []byte {
+	var (
+		sb = &strings.Builder{}
+	)
+	for i := 0; i < JVO_END; i++ {
+		if i > 0 {
+			sb.WriteString(SEP)
+		}
+		switch v := job.ValFieldAddr(i).(type) {
+		case *string:
+			sb.WriteString(strings.ReplaceAll(*v, SEP, SEP_GRP)) // free text such as error output
+		case *int:
+			sb.WriteString(strconv.Itoa(*v))
+		case *time.Time:
+			sb.WriteString(v.Format(time.RFC3339Nano)) // text, binary time may contain SEP
+		default:
+			panic("need more type for marshaling value")
+		}
+	}
+	return []byte(sb.String())
+}
+
+func (job *Job) Marshal(at any) (forKey, forValue []byte) {
+	return job.Key(), job.Value()
+}
+
+func (job *Job) Unmarshal(dbKey, dbVal []byte) (any, error) {
+	job.Id = string(dbKey)
+	for i, seg := range bytes.Split(dbVal, []byte(SEP)) {
+		if i == JVO_END {
+			break
+		}
+		switch v := job.ValFieldAddr(i).(type) {
+		case *string:
+			*v = string(seg)
+		case *int:
+			n, err := strconv.Atoi(string(seg))
+			if err != nil {
+				return nil, err
+			}
+			*v = n
+		case *time.Time:
+			t, err := time.Parse(time.RFC3339Nano, string(seg))
+			if err != nil {
+				return nil, err
+			}
+			*v = t
+		default:
+			panic("Unmarshal Error Type")
+		}
+	}
+	return job, nil
+}
+
+///////////////////////////////////////////////////
+
+const maxIdle = time.Minute // pending jobs are scanned at least this often
+
+var (
+	lastJobSeq int64     // guarded by DbGrp
+	dueAt      time.Time // no pending job is due before it, zero needs a scan. guarded by DbGrp
+)
+
+// pending 'job' may be due before 'dueAt'
+func wakeAt(job *Job) {
+	if job.Status == JobPending && job.NotBefore.Before(dueAt) {
+		dueAt = job.NotBefore
+	}
+}
+
+// AddJob stores a new pending job of 'kind' for FileItem 'fiId' of 'user'
+func AddJob(user, fiId, kind, args string) (*Job, error) {
+	DbGrp.Lock()
+	defer DbGrp.Unlock()
+
+	now := time.Now()
+	lastJobSeq = max(now.UnixNano(), lastJobSeq+1)
+	job := &Job{
+		Id:        fmt.Sprintf("%020d", lastJobSeq),
+		User:      user,
+		FiId:      fiId,
+		Kind:      kind,
+		Args:      args,
+		Status:    JobPending,
+		Created:   now,
+		Updated:   now,
+		NotBefore: now,
+	}
+	wakeAt(job)
+	return job, bh.UpsertOneObject(job)
+}
+
+func UpdateJob(job *Job) error {
+	DbGrp.Lock()
+	defer DbGrp.Unlock()
+
+	wakeAt(job)
+	return bh.UpsertOneObject(job)
+}
+
+// jobs sorted by creation, 'filter' nil lists all
+func ListJobs(filter func(*Job) bool) ([]*Job, error) {
+	DbGrp.Lock()
+	defer DbGrp.Unlock()
+
+	jobs, err := bh.GetObjects([]byte(""), filter)
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Id < jobs[j].Id })
+	return jobs, err
+}
+
+func GetJob(id string) (*Job, error) {
+	jobs, err := ListJobs(func(job *Job) bool { return job.Id == id })
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return jobs[0], nil
+}
+
+// ClaimJob marks the earliest due pending job as running and returns it, nil if none is due.
+// Pending jobs are only scanned when one may be due, as known from adding & updating jobs.
+func ClaimJob() (*Job, error) {
+	DbGrp.Lock()
+	defer DbGrp.Unlock()
+
+	now := time.Now()
+	if now.Before(dueAt) {
+		return nil, nil
+	}
+	jobs, err := bh.GetObjects([]byte(""), func(job *Job) bool { return job.Status == JobPending })
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Id < jobs[j].Id })
+	dueAt = now.Add(maxIdle)
+	var claimed *Job
+	for _, job := range jobs {
+		if claimed == nil && !job.NotBefore.After(now) {
+			claimed = job
+			continue
+		}
+		if job.NotBefore.Before(dueAt) {
+			dueAt = job.NotBefore
+		}
+	}
+	if claimed == nil {
+		return nil, nil
+	}
+	claimed.Status, claimed.Updated, claimed.Progress = JobRunning, now, 0
+	return claimed, bh.UpsertOneObject(claimed)
+}
+
+// ResetRunningJobs sets jobs left running, such as by a crash, back to pending
+func ResetRunningJobs() (int, error) {
+	jobs, err := ListJobs(func(job *Job) bool { return job.Status == JobRunning })
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range jobs {
+		job.Status, job.Updated = JobPending, time.Now()
+		if err := UpdateJob(job); err != nil {
+			return 0, err
+		}
+	}
+	return len(jobs), nil
+}
+
+// DeleteJobs removes jobs matching 'filter', return number of removed ones
+func DeleteJobs(filter func(*Job) bool) (int, error) {
+	DbGrp.Lock()
+	defer DbGrp.Unlock()
+
+	jobs, err := bh.GetObjects([]byte(""), filter)
+	if err != nil {
+		return 0, err
+	}
+	ops := make([]func(txn *badger.Txn) error, 0, len(jobs))
+	for _, job := range jobs {
+		ops = append(ops, func(txn *badger.Txn) error { return txn.Delete(job.Key()) })
+	}
+	return writeBatches(DbGrp.Job, ops)
+}
+
+// EditJobs applies 'edit' to jobs matching 'filter' and stores them, return number of stored ones
+func EditJobs(filter func(*Job) bool, edit func(job *Job)) (int, error) {
+	DbGrp.Lock()
+	defer DbGrp.Unlock()
+
+	jobs, err := bh.GetObjects([]byte(""), filter)
+	if err != nil {
+		return 0, err
+	}
+	ops := make([]func(txn *badger.Txn) error, 0, len(jobs))
+	for _, job := range jobs {
+		edit(job)
+		wakeAt(job)
+		ops = append(ops, func(txn *badger.Txn) error { return txn.Set(job.Marshal(nil)) })
+	}
+	return writeBatches(DbGrp.Job, ops)
+}
+
+// PurgeJobs removes done & failed jobs finished before 'before'
+func PurgeJobs(before time.Time) (int, error) {
+	return DeleteJobs(func(job *Job) bool {
+		return (job.Status == JobDone || job.Status == JobFailed) && job.Updated.Before(before)
+	})
+}
@@ -0,0 +1,47 @@
+package fdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	lk "github.com/digisan/logkit"
+)
+
+func TestJob(t *testing.T) {
+	InitDB("")
+	defer CloseDB()
+
+	job, err := AddJob("user", "fileitem-id", "process", `{"Rotate":90}`)
+	lk.FailOnErr("%v", err)
+	job.Err = "ffmpeg failed: a^^b"
+	job.Tries = 2
+	lk.FailOnErr("%v", UpdateJob(job))
+
+	next, err := AddJob("user", "fileitem-id", "thumbnail", `[128]`)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(next.Id <= job.Id, "%v", fmt.Errorf("job order: %s <= %s", next.Id, job.Id))
+
+	got, err := GetJob(job.Id)
+	lk.FailOnErr("%v", err)
+	fmt.Println(got)
+	lk.FailOnErrWhen(got.Tries != 2 || got.Args != job.Args || !got.Created.Equal(job.Created), "%v", fmt.Errorf("round trip: %v", got))
+
+	// claimed in order, running jobs reset as pending
+	claimed, err := ClaimJob()
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(claimed.Id != job.Id || claimed.Status != JobRunning, "%v", fmt.Errorf("claimed: %v", claimed))
+	n, err := ResetRunningJobs()
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(n != 1, "%v", fmt.Errorf("reset: %d", n))
+
+	// not due yet
+	next.NotBefore = time.Now().Add(time.Hour)
+	lk.FailOnErr("%v", UpdateJob(next))
+	n, err = DeleteJobs(func(j *Job) bool { return j.Id == job.Id })
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(n != 1, "%v", fmt.Errorf("deleted: %d", n))
+	claimed, err = ClaimJob()
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(claimed != nil, "%v", fmt.Errorf("claimed job not due: %v", claimed))
+}
@@ -0,0 +1,176 @@
+package fdb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Meta is content metadata extracted once at saving, zero values are unknown or not applicable
+type Meta struct {
+	Size     int64   `json:"size,omitempty"`     // bytes
+	MIME     string  `json:"mime,omitempty"`     // sniffed from content, falls back to extension
+	Hash     string  `json:"hash,omitempty"`     // md5 hex of content
+	Width    int     `json:"width,omitempty"`    // pixels of image or video
+	Height   int     `json:"height,omitempty"`   // pixels of image or video
+	Duration float64 `json:"duration,omitempty"` // seconds of audio, video or one play of animated image
+	Codec    string  `json:"codec,omitempty"`    // such as "jpeg", "h264"
+	Pages    int     `json:"pages,omitempty"`    // pages of document
+	Frames   int     `json:"frames,omitempty"`   // frames of animated GIF or APNG
+	Snippet  string  `json:"snippet,omitempty"`  // preview of extracted document text
+	// EXIF & XMP of image
+	Taken       string  `json:"taken,omitempty"`       // capture time, RFC3339 or without zone as "2006-01-02T15:04:05"
+	Make        string  `json:"make,omitempty"`        // camera maker
+	Model       string  `json:"model,omitempty"`       // camera model
+	Orientation int     `json:"orientation,omitempty"` // EXIF orientation 1-8
+	GPS         bool    `json:"gps,omitempty"`         // location is present
+	Lat         float64 `json:"lat,omitempty"`         // degrees, south is negative
+	Lon         float64 `json:"lon,omitempty"`         // degrees, west is negative
+	// perceptual hashes of image, 64 bits hex, near duplicates differ in few bits
+	AHash string `json:"ahash,omitempty"` // average hash
+	DHash string `json:"dhash,omitempty"` // difference hash
+	PHash string `json:"phash,omitempty"` // DCT hash, most robust to resizing & recompression
+	// placeholders of image, rendered by clients before fetching content
+	Blurhash string `json:"blurhash,omitempty"` // https://blurha.sh
+	Color    string `json:"color,omitempty"`    // dominant color as "#rrggbb"
+	// audio stream & tags, such as ID3
+	Bitrate    int    `json:"bitrate,omitempty"`     // bits per second of audio or video
+	SampleRate int    `json:"sample_rate,omitempty"` // Hz
+	Channels   int    `json:"channels,omitempty"`    // 1 mono, 2 stereo
+	Title      string `json:"title,omitempty"`
+	Artist     string `json:"artist,omitempty"`
+	Album      string `json:"album,omitempty"`
+	Year       string `json:"year,omitempty"`
+	Genre      string `json:"genre,omitempty"`
+	Track      int    `json:"track,omitempty"`
+	Peaks      string `json:"peaks,omitempty"` // base64 waveform peaks of audio, one byte each, 255 is full scale
+}
+
+// base64 JSON, free of SEP. zero Meta is empty
+func (m *Meta) encode() []byte {
+	if *m == (Meta{}) {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return []byte(base64.StdEncoding.EncodeToString(data))
+}
+
+func (m *Meta) decode(seg []byte) error {
+	*m = Meta{}
+	if len(seg) == 0 {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(string(seg))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, m)
+}
+
+// metadata is extracted, FileItems saved before metadata need migration
+func (fi *FileItem) HasMeta() bool {
+	return fi.Meta.Hash != ""
+}
+
+// bytes of content
+func (fi *FileItem) Size() int64 {
+	return fi.Meta.Size
+}
+
+// MIME type sniffed from content, such as "image/png"
+func (fi *FileItem) MIME() string {
+	return fi.Meta.MIME
+}
+
+// md5 hex of content
+func (fi *FileItem) Hash() string {
+	return fi.Meta.Hash
+}
+
+// pixels of image or video, 0 if unknown
+func (fi *FileItem) Dimensions() (width, height int) {
+	return fi.Meta.Width, fi.Meta.Height
+}
+
+// length of audio, video or one play of animated image, 0 if unknown
+func (fi *FileItem) Duration() time.Duration {
+	return time.Duration(fi.Meta.Duration * float64(time.Second))
+}
+
+// codec of image or main stream of video, audio
+func (fi *FileItem) Codec() string {
+	return fi.Meta.Codec
+}
+
+// pages of document, 0 if unknown
+func (fi *FileItem) Pages() int {
+	return fi.Meta.Pages
+}
+
+// frames of animated image, 0 if still or unknown
+func (fi *FileItem) Frames() int {
+	return fi.Meta.Frames
+}
+
+// preview of document text, full text is kept for search
+func (fi *FileItem) Snippet() string {
+	return fi.Meta.Snippet
+}
+
+// capture time of photo, falls back to saving time
+func (fi *FileItem) Taken() time.Time {
+	if t, err := time.Parse(time.RFC3339, fi.Meta.Taken); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05", fi.Meta.Taken, time.Local); err == nil {
+		return t
+	}
+	return fi.Tm
+}
+
+// camera as "make model", empty if unknown
+func (fi *FileItem) Camera() string {
+	if strings.HasPrefix(fi.Meta.Model, fi.Meta.Make) {
+		return fi.Meta.Model // such as "Canon" & "Canon EOS R5"
+	}
+	return strings.TrimSpace(fi.Meta.Make + " " + fi.Meta.Model)
+}
+
+// latitude & longitude in degrees, 'ok' false if absent
+func (fi *FileItem) Location() (lat, lon float64, ok bool) {
+	return fi.Meta.Lat, fi.Meta.Lon, fi.Meta.GPS
+}
+
+// blurhash & dominant color of image for previews without content, empty if unknown
+func (fi *FileItem) Placeholder() (blurhash, color string) {
+	return fi.Meta.Blurhash, fi.Meta.Color
+}
+
+// waveform of audio as peaks in 0-1 over equal time slices, nil if unknown
+func (fi *FileItem) Waveform() []float64 {
+	data, err := base64.StdEncoding.DecodeString(fi.Meta.Peaks)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	peaks := make([]float64, len(data))
+	for i, b := range data {
+		peaks[i] = float64(b) / 255
+	}
+	return peaks
+}
+
+// sort 'fis' by capture time, then by camera
+func SortByTaken(fis []*FileItem) {
+	sort.SliceStable(fis, func(i, j int) bool {
+		ti, tj := fis[i].Taken(), fis[j].Taken()
+		if ti.Equal(tj) {
+			return fis[i].Camera() < fis[j].Camera()
+		}
+		return ti.Before(tj)
+	})
+}
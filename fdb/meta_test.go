@@ -0,0 +1,27 @@
+package fdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	lk "github.com/digisan/logkit"
+)
+
+func TestMeta(t *testing.T) {
+	fi := FileItem{Tm: time.Now(), Path: "a/b/c", Meta: Meta{Size: 10, MIME: "image/png", Hash: "abc", Codec: "a^^b", Width: 3, Height: 2}}
+	dbKey, dbVal := fi.Marshal(nil)
+
+	got := FileItem{}
+	_, err := got.Unmarshal(dbKey, dbVal)
+	lk.FailOnErr("%v", err)
+	fmt.Println(got)
+	lk.FailOnErrWhen(got.Meta != fi.Meta || got.Path != fi.Path, "%v", fmt.Errorf("round trip: %v", got))
+
+	// saved before metadata
+	old := FileItem{Tm: time.Now(), Path: "a/b/c"}
+	dbKey, dbVal = old.Marshal(nil)
+	_, err = got.Unmarshal(dbKey, dbVal)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(got.HasMeta(), "%v", fmt.Errorf("empty meta: %v", got.Meta))
+}
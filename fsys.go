@@ -0,0 +1,241 @@
+package filemgr
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+)
+
+// FS returns a read-only fs.FS of user's FileItems, laid out as "month/group.../type/file".
+// Only FileItems owned by this user are visible. Paths are indexed when it's made, call FS again after UserSpace is modified.
+func (us *UserSpace) FS() fs.FS {
+	return newUserFS(us, "")
+}
+
+// userFS implements fs.FS, fs.ReadDirFS, fs.StatFS & fs.SubFS
+type userFS struct {
+	us       *UserSpace
+	dir      string                   // sub directory, "" is user space root
+	files    map[string]*fdb.FileItem // fs path => FileItem
+	children map[string][]string      // fs path of directory => sorted names in it, "." is root
+}
+
+// userFS of 'dir' with path index of FileItems in it, built once
+func newUserFS(us *UserSpace, dir string) *userFS {
+	ufs := &userFS{us: us, dir: dir, files: map[string]*fdb.FileItem{}, children: map[string][]string{".": nil}}
+	seen := map[string]struct{}{}
+	for _, fi := range us.FIs {
+		rel := ufs.relOf(fi)
+		if rel == "" {
+			continue
+		}
+		ufs.files[rel] = fi
+		for parent, name := path.Dir(rel), path.Base(rel); ; parent, name = path.Dir(parent), path.Base(parent) {
+			if _, ok := seen[parent+"/"+name]; ok {
+				break
+			}
+			seen[parent+"/"+name] = struct{}{}
+			ufs.children[parent] = append(ufs.children[parent], name)
+			if parent == "." {
+				break
+			}
+		}
+	}
+	for _, names := range ufs.children {
+		sort.Strings(names)
+	}
+	return ufs
+}
+
+// fs path, relative to userFS.dir, of fileItem. "" if it's not in userFS.dir
+func (ufs *userFS) relOf(fi *fdb.FileItem) string {
+	if !ufs.us.Own(fi) {
+		return ""
+	}
+	rel := filepath.ToSlash(strings.TrimPrefix(fi.Path, ufs.us.UserPath))
+	if ufs.dir == "" {
+		return rel
+	}
+	if !strings.HasPrefix(rel, ufs.dir+"/") {
+		return ""
+	}
+	return strings.TrimPrefix(rel, ufs.dir+"/")
+}
+
+// file item at 'name', or children names of directory 'name'
+func (ufs *userFS) lookup(name string) (fi *fdb.FileItem, children []string, isDir bool) {
+	if fi, ok := ufs.files[name]; ok {
+		return fi, nil, false
+	}
+	children, isDir = ufs.children[name]
+	return nil, children, isDir
+}
+
+func (ufs *userFS) fileInfo(fi *fdb.FileItem) (*fsInfo, error) {
+	info, err := os.Stat(fi.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &fsInfo{name: path.Base(ufs.relOf(fi)), size: info.Size(), tm: fi.Tm}, nil
+}
+
+func (ufs *userFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	fi, children, isDir := ufs.lookup(name)
+	switch {
+	case fi != nil:
+		info, err := ufs.fileInfo(fi)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		f, err := os.Open(fi.Path)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &fsFile{File: f, info: info}, nil
+	case isDir:
+		entries, err := ufs.entries(name, children)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &fsDir{info: &fsInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+	default:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+}
+
+func (ufs *userFS) entries(name string, children []string) ([]fs.DirEntry, error) {
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		info := &fsInfo{name: child, dir: true}
+		if fi, ok := ufs.files[path.Join(name, child)]; ok {
+			var err error
+			if info, err = ufs.fileInfo(fi); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+func (ufs *userFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	fi, children, isDir := ufs.lookup(name)
+	if fi != nil || !isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries, err := ufs.entries(name, children)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (ufs *userFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	fi, _, isDir := ufs.lookup(name)
+	switch {
+	case fi != nil:
+		info, err := ufs.fileInfo(fi)
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+		return info, nil
+	case isDir:
+		return &fsInfo{name: path.Base(name), dir: true}, nil
+	default:
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+}
+
+func (ufs *userFS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	if dir == "." {
+		return ufs, nil
+	}
+	fi, _, isDir := ufs.lookup(dir)
+	if fi != nil || !isDir {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrNotExist}
+	}
+	if ufs.dir != "" {
+		dir = ufs.dir + "/" + dir
+	}
+	return newUserFS(ufs.us, dir), nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+type fsInfo struct {
+	name string
+	size int64
+	tm   time.Time
+	dir  bool
+}
+
+func (fi *fsInfo) Name() string       { return fi.name }
+func (fi *fsInfo) Size() int64        { return fi.size }
+func (fi *fsInfo) ModTime() time.Time { return fi.tm }
+func (fi *fsInfo) IsDir() bool        { return fi.dir }
+func (fi *fsInfo) Sys() any           { return nil }
+func (fi *fsInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// fsFile is FileItem content, its info is from FileItem
+type fsFile struct {
+	*os.File
+	info *fsInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// fsDir is a directory of month, group or type
+type fsDir struct {
+	info    *fsInfo
+	entries []fs.DirEntry
+	pos     int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.pos:]
+	if n <= 0 {
+		d.pos = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n < len(rest) {
+		rest = rest[:n]
+	}
+	d.pos += len(rest)
+	return rest, nil
+}
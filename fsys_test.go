@@ -0,0 +1,62 @@
+package filemgr
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	lk "github.com/digisan/logkit"
+)
+
+func TestFS(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("fs test")
+	lk.FailOnErr("%v", err)
+
+	for i, grps := range [][]string{{"G0", "G1"}, {"G0", "G2"}, {"G3"}} {
+		_, err := us.SaveFile(strings.NewReader(fmt.Sprintf("content %d", i)), fmt.Sprintf("f%d.txt", i), "", nil, i != 2, grps...)
+		lk.FailOnErr("%v", err)
+	}
+
+	expected := []string{}
+	for _, fi := range us.FIs {
+		expected = append(expected, filepath.ToSlash(strings.TrimPrefix(fi.Path, us.UserPath)))
+	}
+	fmt.Println(expected)
+
+	fsys := us.FS()
+	lk.FailOnErr("%v", fstest.TestFS(fsys, expected...))
+
+	lk.FailOnErr("%v", fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		fmt.Println(p, d.IsDir())
+		return err
+	}))
+
+	// sub tree of a group
+	sub, err := fs.Sub(fsys, path.Dir(path.Dir(expected[0])))
+	lk.FailOnErr("%v", err)
+	lk.FailOnErr("%v", fstest.TestFS(sub, path.Join(path.Base(path.Dir(expected[0])), path.Base(expected[0]))))
+
+	// standard tooling
+	srv := httptest.NewServer(http.FileServer(http.FS(fsys)))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/" + expected[0])
+	lk.FailOnErr("%v", err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	lk.FailOnErr("%v", err)
+	fmt.Println(resp.Status, string(data))
+	lk.FailOnErrWhen(resp.StatusCode != http.StatusOK, "%v", fmt.Errorf("GET: %s", resp.Status))
+
+	_, err = fsys.Open("../" + expected[0])
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("invalid path opened"))
+}
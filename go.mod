@@ -10,6 +10,8 @@ require (
 	github.com/digisan/logkit v0.3.8
 	github.com/google/uuid v1.1.2
 	github.com/jtguibas/cinema v0.0.0-20200208054232-ca271f28a020
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.25.0
 )
 
 require (
@@ -28,7 +30,6 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
 	go.opencensus.io v0.24.0 // indirect
-	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	google.golang.org/protobuf v1.34.1 // indirect
 )
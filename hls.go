@@ -0,0 +1,204 @@
+package filemgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+	"github.com/jtguibas/cinema"
+)
+
+const (
+	JobHLS = "hls" // args: HLS variants
+
+	hlsMaster  = "master.m3u8"
+	hlsSegment = 6 // seconds
+)
+
+// HLSVariant is one bitrate level of HLS package
+type HLSVariant struct {
+	Height  int `json:"height"`  // output height keeping aspect ratio, larger than original keeps original
+	Bitrate int `json:"bitrate"` // video kbps
+}
+
+func (v HLSVariant) validate() error {
+	if v.Height <= 0 || v.Bitrate <= 0 {
+		return fmt.Errorf("HLS variant %dp@%dk is invalid", v.Height, v.Bitrate)
+	}
+	return nil
+}
+
+// "root/name/.fi/id/hls"
+func (us *UserSpace) hlsDir(fi *fdb.FileItem) string {
+	return filepath.Join(us.artifactDir(fi), "hls")
+}
+
+// sudo apt install ffmpeg
+// ffmpeg arguments segmenting 'in' as variant 'v' into 'dir', progress is written to stdout
+func (v HLSVariant) args(in, dir string) []string {
+	return []string{"-y", "-nostdin", "-i", in,
+		"-vf", fmt.Sprintf("scale=-2:%d", v.Height/2*2),
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-b:v", fmt.Sprintf("%dk", v.Bitrate), "-maxrate", fmt.Sprintf("%dk", v.Bitrate*107/100),
+		"-bufsize", fmt.Sprintf("%dk", v.Bitrate*3/2),
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegment), "-sc_threshold", "0", // segment starts with key frame at any frame rate
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+		"-f", "hls", "-hls_time", fmt.Sprint(hlsSegment), "-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "seg-%03d.ts"),
+		"-progress", "pipe:1", "-nostats", filepath.Join(dir, "index.m3u8"),
+	}
+}
+
+// package video 'fi' as HLS variants 'vs' into its artifact directory, replacing previous package
+func (us *UserSpace) packageHLS(fi *fdb.FileItem, vs []HLSVariant, progress func(pct float64)) error {
+	video, err := cinema.Load(fi.Path)
+	if err != nil {
+		return err
+	}
+	w, h := video.Width(), video.Height()
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("[%s] has invalid video size %dx%d", fi.Id, w, h)
+	}
+
+	tmp := fmt.Sprintf("%s-%d", us.hlsDir(fi), time.Now().UnixNano())
+	defer os.RemoveAll(tmp)
+
+	master := &strings.Builder{}
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	done := map[int]bool{}
+	for i, v := range vs {
+		v.Height = min(v.Height, h) / 2 * 2 // never upscale
+		if done[v.Height] {
+			continue
+		}
+		done[v.Height] = true
+
+		name := fmt.Sprintf("%dp", v.Height)
+		dir := filepath.Join(tmp, name)
+		fd.MustCreateDir(dir)
+		err := runFFmpeg(v.args(fi.Path, dir), video.Duration(), func(pct float64) {
+			if progress != nil {
+				progress((float64(i) + pct/100) * 100 / float64(len(vs)))
+			}
+		})
+		if err != nil {
+			return err
+		}
+		width := (w*v.Height/h + 1) / 2 * 2
+		fmt.Fprintf(master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s/index.m3u8\n",
+			(v.Bitrate+128)*1000, width, v.Height, name)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, hlsMaster), []byte(master.String()), 0o644); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(us.hlsDir(fi)); err != nil {
+		return err
+	}
+	return os.Rename(tmp, us.hlsDir(fi))
+}
+
+func (us *UserSpace) hlsVideo(id string, vs []HLSVariant) (*fdb.FileItem, error) {
+	for _, v := range vs {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) == 0 {
+		return nil, fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	if fis[0].Type() != fd.Video {
+		return nil, fmt.Errorf("[%s] is %s, only video can be packaged as HLS", id, fis[0].Type())
+	}
+	return fis[0], nil
+}
+
+// PackageHLS segments first video FileItem matching 'id' as HLS variants 'vs', default 'OptHLSVariants',
+// and returns path of its master playlist. Package is stored with FileItem and removed along with it.
+// 'progress' receives overall percentage, can be nil.
+func (us *UserSpace) PackageHLS(id string, progress func(pct float64), vs ...HLSVariant) (string, error) {
+	if len(vs) == 0 {
+		vs = opt.hlsVariants
+	}
+	fi, err := us.hlsVideo(id, vs)
+	if err != nil {
+		return "", err
+	}
+	if err := us.packageHLS(fi, vs, progress); err != nil {
+		return "", err
+	}
+	return filepath.Join(us.hlsDir(fi), hlsMaster), nil
+}
+
+// QueuePackageHLS queues HLS packaging of first video FileItem matching 'id' in background
+func (us *UserSpace) QueuePackageHLS(id string, vs ...HLSVariant) (*fdb.Job, error) {
+	if len(vs) == 0 {
+		vs = opt.hlsVariants
+	}
+	fi, err := us.hlsVideo(id, vs)
+	if err != nil {
+		return nil, err
+	}
+	return us.queue(fi, JobHLS, vs)
+}
+
+func runHLSJob(us *UserSpace, fi *fdb.FileItem, args string, progress func(pct float64)) error {
+	vs := []HLSVariant{}
+	if err := json.Unmarshal([]byte(args), &vs); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return us.packageHLS(fi, vs, progress)
+}
+
+// HLSFile returns path of 'name' in HLS package of first FileItem matching 'id',
+// empty 'name' for master playlist, others are relative such as "720p/index.m3u8", "720p/seg-000.ts"
+func (us *UserSpace) HLSFile(id, name string) (string, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return "", err
+	}
+	if len(fis) == 0 {
+		return "", fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	if name == "" {
+		name = hlsMaster
+	}
+	name = filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+PS) {
+		return "", fmt.Errorf("HLS file [%s] is invalid", name)
+	}
+	path := filepath.Join(us.hlsDir(fis[0]), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("HLS file [%s] of [%s] %w", name, id, os.ErrNotExist)
+	}
+	return path, nil
+}
+
+// ServeHLS replies to 'r' with 'name' of HLS package, see HLSFile
+func (us *UserSpace) ServeHLS(w http.ResponseWriter, r *http.Request, id, name string) {
+	path, err := us.HLSFile(id, name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filepath.Ext(path) == ".m3u8" {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+	http.ServeFile(w, r, path)
+}
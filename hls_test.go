@@ -0,0 +1,83 @@
+package filemgr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/digisan/file-mgr/fdb"
+	lk "github.com/digisan/logkit"
+)
+
+func TestPackageHLS(t *testing.T) {
+
+	fakeFFmpeg(t)
+	InitFileMgr("./data")
+
+	us, err := UseUser("hls test")
+	lk.FailOnErr("%v", err)
+
+	_, err = us.SaveFile(bytes.NewReader(largeMedia(1<<20)), "lecture.mp4", "", nil, false, "media")
+	lk.FailOnErr("%v", err)
+	fi := us.FIs[len(us.FIs)-1]
+
+	_, err = us.PackageHLS(fi.Id, nil, HLSVariant{Height: 360})
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("zero bitrate should be rejected"))
+	err = runHLSJob(us, fi, "{broken", nil)
+	lk.FailOnErrWhen(!errors.Is(err, ErrInvalid), "%v", fmt.Errorf("broken args MUST be invalid, got %v", err))
+
+	args := strings.Join(HLSVariant{Height: 360, Bitrate: 800}.args("in.mp4", "out"), " ")
+	lk.FailOnErrWhen(!strings.Contains(args, fmt.Sprintf("-force_key_frames expr:gte(t,n_forced*%d)", hlsSegment)) || strings.Contains(args, " -g "),
+		"%v", fmt.Errorf("key frames MUST follow segment time, got %s", args))
+
+	pcts := []float64{}
+	master, err := us.PackageHLS(fi.Id, func(pct float64) { pcts = append(pcts, pct) },
+		HLSVariant{Height: 360, Bitrate: 800}, HLSVariant{Height: 1080, Bitrate: 5000})
+	lk.FailOnErr("%v", err)
+	data, err := os.ReadFile(master)
+	lk.FailOnErr("%v", err)
+	info, err := os.Stat(master)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(info.Mode().Perm() != 0o644, "%v", fmt.Errorf("master playlist mode %v", info.Mode()))
+	fmt.Println(string(data), pcts)
+	lk.FailOnErrWhen(!strings.Contains(string(data), "RESOLUTION=640x360\n360p/index.m3u8"), "%v", fmt.Errorf("360p missing"))
+	lk.FailOnErrWhen(!strings.Contains(string(data), "RESOLUTION=1280x720\n720p/index.m3u8"), "%v", fmt.Errorf("1080p MUST be capped to 720p"))
+
+	path, err := us.HLSFile(fi.Id, "")
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(path != master, "%v", fmt.Errorf("master %s", path))
+	_, err = us.HLSFile(fi.Id, "../../../x")
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("traversal should be rejected"))
+
+	rec := httptest.NewRecorder()
+	us.ServeHLS(rec, httptest.NewRequest("GET", "/hls/360p/seg-000.ts", nil), fi.Id, "360p/seg-000.ts")
+	lk.FailOnErrWhen(rec.Code != 200 || rec.Header().Get("Content-Type") != "video/mp2t", "%v", fmt.Errorf("serve %d", rec.Code))
+	rec = httptest.NewRecorder()
+	us.ServeHLS(rec, httptest.NewRequest("GET", "/hls/480p/index.m3u8", nil), fi.Id, "480p/index.m3u8")
+	lk.FailOnErrWhen(rec.Code != 404, "%v", fmt.Errorf("serve missing %d", rec.Code))
+
+	// background
+	lk.FailOnErr("%v", StartJobs(1))
+	job, err := us.QueuePackageHLS(fi.Id, HLSVariant{Height: 240, Bitrate: 400})
+	lk.FailOnErr("%v", err)
+	for _, j := range waitJobs(us, fi.Id) {
+		if j.Id == job.Id {
+			lk.FailOnErrWhen(j.Status != fdb.JobDone, "%v", fmt.Errorf("job %s %s", j.Status, j.Err))
+		}
+	}
+	StopJobs()
+	_, err = us.HLSFile(fi.Id, "240p/index.m3u8")
+	lk.FailOnErr("%v", err)
+	_, err = us.HLSFile(fi.Id, "360p/index.m3u8")
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("previous package should be replaced"))
+
+	// removed along with FileItem
+	dir := us.hlsDir(fi)
+	lk.FailOnErr("%v", us.DelFileItem(fi.Id))
+	_, err = os.Stat(dir)
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("HLS package should be removed"))
+}
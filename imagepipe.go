@@ -0,0 +1,304 @@
+package filemgr
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+const (
+	ResizeFit  = "fit"  // scale to fit inside Width x Height, keeping aspect ratio
+	ResizeFill = "fill" // scale to cover Width x Height keeping aspect ratio, then crop center
+)
+
+// ImageTransform is a pipeline on image content, in order of EXIF orientation correction,
+// rotate, flip, resize, watermark, then encoding as 'Format'
+type ImageTransform struct {
+	AutoOrient bool       // upright image by its EXIF orientation
+	Rotate     int        // clockwise degrees, multiple of 90
+	FlipH      bool       // mirror left & right
+	FlipV      bool       // mirror top & bottom
+	Resize     string     // ResizeFit, ResizeFill, empty for no resizing
+	Width      int        // box width of 'Resize', 0 is unbounded for ResizeFit
+	Height     int        // box height of 'Resize', 0 is unbounded for ResizeFit
+	Format     string     // "png", "jpg", "gif", empty keeps jpg & gif as they are, others as png
+	Quality    int        // jpg quality 1-100, 0 for 90
+	Watermark  *Watermark // overlay drawn after resizing, nil for none
+}
+
+func (t *ImageTransform) validate() error {
+	if t.Rotate%90 != 0 {
+		return fmt.Errorf("rotate [%d] is not multiple of 90", t.Rotate)
+	}
+	switch t.Resize {
+	case "":
+	case ResizeFit:
+		if t.Width < 0 || t.Height < 0 || t.Width+t.Height == 0 {
+			return fmt.Errorf("fit box %dx%d is invalid", t.Width, t.Height)
+		}
+	case ResizeFill:
+		if t.Width <= 0 || t.Height <= 0 {
+			return fmt.Errorf("fill box %dx%d is invalid", t.Width, t.Height)
+		}
+	default:
+		return fmt.Errorf("resize [%s] is unsupported, only [%s %s]", t.Resize, ResizeFit, ResizeFill)
+	}
+	if t.Quality < 0 || t.Quality > 100 {
+		return fmt.Errorf("quality [%d] is out of 1-100", t.Quality)
+	}
+	if f := strings.ToLower(strings.TrimPrefix(t.Format, ".")); f != "" && NotIn(f, "png", "jpg", "jpeg", "gif") {
+		return fmt.Errorf("image format [%s] is unsupported, only %v", t.Format, imageFormats)
+	}
+	if t.Watermark != nil {
+		return t.Watermark.validate()
+	}
+	return nil
+}
+
+// copy of 't' with watermark image resolved in 'us'
+func (t *ImageTransform) resolve(us *UserSpace) (*ImageTransform, error) {
+	if t.Watermark == nil {
+		return t, nil
+	}
+	wm, err := t.Watermark.resolve(us)
+	if err != nil {
+		return nil, err
+	}
+	v := *t
+	v.Watermark = wm
+	return &v, nil
+}
+
+// mirror left & right, or top & bottom if 'vertical'
+func flipImage(img image.Image, vertical bool) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if vertical {
+				dst.Set(x, b.Dy()-1-y, img.At(b.Min.X+x, b.Min.Y+y))
+			} else {
+				dst.Set(b.Dx()-1-x, y, img.At(b.Min.X+x, b.Min.Y+y))
+			}
+		}
+	}
+	return dst
+}
+
+// upright image of EXIF 'orientation' 1-8
+func orientImage(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return flipImage(img, false)
+	case 3:
+		return rotateImage(img, 180)
+	case 4:
+		return flipImage(img, true)
+	case 5:
+		return flipImage(rotateImage(img, 90), false)
+	case 6:
+		return rotateImage(img, 90)
+	case 7:
+		return flipImage(rotateImage(img, 270), false)
+	case 8:
+		return rotateImage(img, 270)
+	}
+	return img
+}
+
+func (t *ImageTransform) resize(img image.Image) image.Image {
+	w, h := float64(img.Bounds().Dx()), float64(img.Bounds().Dy())
+	bw, bh := float64(t.Width), float64(t.Height)
+	switch t.Resize {
+	case ResizeFit:
+		if bw == 0 {
+			bw = math.Inf(1)
+		}
+		if bh == 0 {
+			bh = math.Inf(1)
+		}
+		scale := min(bw/w, bh/h)
+		return resizeImage(img, max(1, int(math.Round(w*scale))), max(1, int(math.Round(h*scale))))
+	case ResizeFill:
+		scale := max(bw/w, bh/h)
+		sw, sh := max(t.Width, int(math.Ceil(w*scale))), max(t.Height, int(math.Ceil(h*scale)))
+		x, y := (sw-t.Width)/2, (sh-t.Height)/2
+		return roi4rgba(resizeImage(img, sw, sh), x, y, x+t.Width, y+t.Height)
+	}
+	return img
+}
+
+// render 't' on image file 'fPath' into a new file beside it, return its path.
+// animated GIF or APNG keeps its animation unless encoded as jpg
+func (t *ImageTransform) render(fPath string) (string, error) {
+	if err := t.validate(); err != nil {
+		return "", err
+	}
+	f, err := os.Open(fPath)
+	if err != nil {
+		return "", err
+	}
+	img, srcFmt, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	anim, err := loadAnimation(fPath)
+	if err != nil {
+		return "", err
+	}
+
+	orientation := 1
+	if t.AutoOrient {
+		orientation = exifOrientation(fPath)
+	}
+	pipe := func(img image.Image) (image.Image, error) {
+		img = orientImage(img, orientation)
+		img = rotateImage(img, (t.Rotate%360+360)%360)
+		if t.FlipH {
+			img = flipImage(img, false)
+		}
+		if t.FlipV {
+			img = flipImage(img, true)
+		}
+		img = t.resize(img)
+		if t.Watermark != nil {
+			return t.Watermark.apply(img)
+		}
+		return img, nil
+	}
+
+	format := strings.ToLower(strings.TrimPrefix(t.Format, "."))
+	switch {
+	case format == "jpeg", format == "" && srcFmt == "jpeg":
+		format = "jpg"
+	case format == "" && srcFmt == "gif":
+		format = "gif"
+	case format == "":
+		format = "png"
+	}
+	out := fmt.Sprintf("%s-%d.%s", strings.TrimSuffix(fPath, filepath.Ext(fPath)), time.Now().UnixNano(), format)
+	switch {
+	case anim != nil && format != "jpg":
+		if err = anim.transform(pipe); err == nil {
+			err = anim.save(out, format)
+		}
+	default:
+		if img, err = pipe(img); err != nil {
+			return "", err
+		}
+		switch format {
+		case "jpg":
+			quality := t.Quality
+			if quality == 0 {
+				quality = 90
+			}
+			_, err = saveJPG(img, out, quality)
+		case "gif":
+			_, err = saveGIF(img, out)
+		default:
+			_, err = savePNG(img, out)
+		}
+	}
+	if err != nil {
+		os.Remove(out)
+		return "", err
+	}
+	return out, nil
+}
+
+// TransformImage applies 't' to first image FileItem matching 'id'.
+// 'derive' false replaces content as a new version keeping id, and returns the same FileItem;
+// 'derive' true saves result as a new FileItem in same groups, linked to the source by 'SrcId'.
+func (us *UserSpace) TransformImage(id string, t *ImageTransform, derive bool) (*fdb.FileItem, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) == 0 {
+		return nil, fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	fi := fis[0]
+	if err := us.syncMemFI(fi); err != nil {
+		return nil, err
+	}
+	if fi.Type() != fd.Image {
+		return nil, fmt.Errorf("[%s] is %s, only image can be transformed", id, fi.Type())
+	}
+	if t, err = t.resolve(us); err != nil {
+		return nil, err
+	}
+	tmp, err := t.render(fi.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if derive {
+		defer os.Remove(tmp)
+		f, err := os.Open(tmp)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		addYM := rYM.MatchString(strings.Split(strings.TrimPrefix(fi.Path, us.UserPath), PS)[0])
+		groups := []string{}
+		if fi.GroupList != "" {
+			groups = strings.Split(fi.GroupList, fdb.SEP_GRP)
+		}
+		name := fi.Name()
+		if cn := convertedName(name, tmp); cn != "" {
+			name = cn
+		}
+		dfi, err := us.saveFile(f, name, "", nil, time.Now(), addYM, groups...)
+		if err != nil {
+			return dfi, err
+		}
+		dfi.SrcId = fi.Id
+		return dfi, us.UpdateFileItem(dfi, false)
+	}
+
+	out := strings.TrimSuffix(fi.Path, filepath.Ext(fi.Path)) + filepath.Ext(tmp)
+	if err := os.Rename(tmp, out); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	oldKey := fi.Id + fi.Path
+	if err := newVersion(fi, out); err != nil {
+		return nil, err
+	}
+	delete(us.IDs, oldKey)
+	us.IDs[fi.Id+fi.Path] = struct{}{}
+	if opt.thumbOnSave {
+		lk.WarnOnErr("%v", us.renderThumbs(fi))
+	}
+	return fi, nil
+}
+
+// Derived lists FileItems derived from FileItems matching 'id', including ones saved by background jobs after loading
+func (us *UserSpace) Derived(id string) ([]*fdb.FileItem, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		ids = append(ids, fi.Id)
+	}
+	stored, err := fdb.ListFileItems(func(fi *fdb.FileItem) bool { return In(fi.SrcId, ids...) && us.Own(fi) })
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range stored {
+		us.adoptFI(fi)
+	}
+	return Filter(us.FIs, func(i int, fi *fdb.FileItem) bool { return In(fi.SrcId, ids...) }), nil
+}
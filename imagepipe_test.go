@@ -0,0 +1,119 @@
+package filemgr
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+// jpeg of left half red & right half blue, with EXIF 'orientation'
+func testJPEG(w, h, orientation int) *bytes.Buffer {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	lk.FailOnErr("%v", jpeg.Encode(buf, img, &jpeg.Options{Quality: 100}))
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	tiff[19] = byte(orientation) // value, big endian SHORT
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	seg := append([]byte{0xFF, 0xE1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}, app1...)
+	data := buf.Bytes()
+	return bytes.NewBuffer(append(append(append([]byte{}, data[:2]...), seg...), data[2:]...))
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xC000 && b < 0x4000
+}
+
+func TestTransformImage(t *testing.T) {
+
+	InitFileMgr("./data")
+	OptThumbOnSave(false)
+	defer OptThumbOnSave(true)
+
+	us, err := UseUser("transform test")
+	lk.FailOnErr("%v", err)
+
+	_, err = us.SaveFile(testJPEG(40, 20, 6), "camera.jpg", "", nil, true, "album")
+	lk.FailOnErr("%v", err)
+	src := us.FIs[len(us.FIs)-1]
+	lk.FailOnErrWhen(exifOrientation(src.Path) != 6, "%v", fmt.Errorf("orientation: %d", exifOrientation(src.Path)))
+
+	// derived, upright & flipped
+	dfi, err := us.TransformImage(src.ID(), &ImageTransform{AutoOrient: true, FlipV: true, Quality: 95}, true)
+	lk.FailOnErr("%v", err)
+	fmt.Println(dfi.Path, dfi.Name(), dfi.SrcId)
+	lk.FailOnErrWhen(dfi.SrcId != src.Id || dfi.GroupList != src.GroupList || filepath.Ext(dfi.Path) != ".jpg", "%v", fmt.Errorf("derived: %v", dfi))
+	img, err := loadImage(dfi.Path)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40, "%v", fmt.Errorf("bounds: %v", img.Bounds()))
+	lk.FailOnErrWhen(isRed(img.At(10, 5)) || !isRed(img.At(10, 35)), "%v", fmt.Errorf("not upright & flipped"))
+
+	derived, err := us.Derived(src.ID())
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(derived) != 1 || derived[0] != dfi, "%v", fmt.Errorf("derived: %v", derived))
+
+	// new version, fill then convert
+	_, err = us.SaveFile(testPNG(400, 300), "wide.png", "", nil, true, "album")
+	lk.FailOnErr("%v", err)
+	fi := us.FIs[len(us.FIs)-1]
+	id := fi.ID()
+	nfi, err := us.TransformImage(id, &ImageTransform{Resize: ResizeFill, Width: 30, Height: 30, Format: "jpg"}, false)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(nfi.ID() != id || nfi.Name() != "wide.jpg", "%v", fmt.Errorf("new version: %v", nfi))
+	img, err = loadImage(nfi.Path)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(img.Bounds().Dx() != 30 || img.Bounds().Dy() != 30, "%v", fmt.Errorf("bounds: %v", img.Bounds()))
+	data, err := os.ReadFile(nfi.Path)
+	lk.FailOnErr("%v", err)
+	hash, err := contentHash(nfi)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(hash != fmt.Sprintf("%x", md5.Sum(data)), "%v", fmt.Errorf("hash of old content: %s", hash))
+
+	// fit keeps aspect ratio
+	nfi, err = us.TransformImage(id, &ImageTransform{Resize: ResizeFit, Width: 20}, false)
+	lk.FailOnErr("%v", err)
+	img, err = loadImage(nfi.Path)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(img.Bounds().Dx() != 20 || img.Bounds().Dy() != 20, "%v", fmt.Errorf("bounds: %v", img.Bounds()))
+
+	for _, tf := range []*ImageTransform{{Rotate: 30}, {Resize: "stretch"}, {Resize: ResizeFill, Width: 10}, {Format: "bmp"}} {
+		_, err := us.TransformImage(id, tf, false)
+		lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("invalid transform accepted: %+v", *tf))
+	}
+
+	// persisted
+	lk.FailOnErr("%v", us.Reload())
+	fis, err := us.FileItems(dfi.ID())
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(fis) != 1 || fis[0].SrcId != src.Id, "%v", fmt.Errorf("reloaded: %v", fis))
+
+	// deleting source unlinks derived
+	lk.FailOnErr("%v", us.DelFileItem(src.ID()))
+	stored, _, err := fdb.FirstFileItem(dfi.ID())
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(fis[0].SrcId != "" || stored.SrcId != "" || !fd.FileExists(stored.Path), "%v", fmt.Errorf("derived NOT unlinked: %v", stored))
+
+	for _, fi := range us.FIs {
+		lk.FailOnErr("%v", us.DelFileItem(fi.ID()))
+	}
+}
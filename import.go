@@ -0,0 +1,279 @@
+package filemgr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ImportResult is the outcome of importing one archive entry or directory file
+type ImportResult struct {
+	Source string `json:"source"`              // entry path in archive, or file path relative to imported directory
+	Id     string `json:"id,omitempty"`        // id of new FileItem
+	Path   string `json:"path,omitempty"`      // storage path of new FileItem
+	Dup    string `json:"duplicate,omitempty"` // id of existing FileItem having same content, nothing imported
+	Err    string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	Imported int            `json:"imported"`
+	Skipped  int            `json:"skipped"` // duplicates
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+func (rpt *ImportReport) add(rst ImportResult) {
+	switch {
+	case rst.Err != "":
+		rpt.Failed++
+	case rst.Dup != "":
+		rpt.Skipped++
+	default:
+		rpt.Imported++
+	}
+	rpt.Results = append(rpt.Results, rst)
+}
+
+// importer holds state of one import, i.e. manifest & known content hashes
+type importer struct {
+	us     *UserSpace
+	addYM  bool
+	groups []string                // leading groups of every imported file
+	items  map[string]ManifestItem // key: ManifestItem.File
+	hashes map[string]string       // content md5 : FileItem id
+	rpt    *ImportReport
+}
+
+func (us *UserSpace) newImporter(addYM bool, groups []string) *importer {
+	imp := &importer{
+		us:     us,
+		addYM:  addYM,
+		groups: groups,
+		items:  map[string]ManifestItem{},
+		hashes: map[string]string{},
+		rpt:    &ImportReport{Results: []ImportResult{}},
+	}
+	for _, fi := range us.FIs {
+		if hash, err := contentHash(fi); err == nil {
+			imp.hashes[hash] = fi.ID()
+		}
+	}
+	return imp
+}
+
+func (imp *importer) loadManifest(r io.Reader) error {
+	mf := &Manifest{}
+	if err := json.NewDecoder(r).Decode(mf); err != nil {
+		return fmt.Errorf("invalid %s: %w", ManifestName, err)
+	}
+	for _, item := range mf.Items {
+		imp.items[item.File] = item
+	}
+	return nil
+}
+
+// one file, 'src' is slash separated path inside archive or directory.
+// 'open' is called twice at most, once for hashing, once for saving.
+func (imp *importer) importFile(src string, open func() (io.ReadCloser, error)) {
+	rst := ImportResult{Source: src}
+	defer func() { imp.rpt.add(rst) }()
+
+	hash, err := func() (string, error) {
+		rc, err := open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		h := md5.New()
+		if _, err := io.Copy(h, rc); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%x", h.Sum(nil)), nil
+	}()
+	if err != nil {
+		rst.Err = err.Error()
+		return
+	}
+	if id, ok := imp.hashes[hash]; ok {
+		rst.Dup = id
+		return
+	}
+
+	// subdirectories are groups, or restore from manifest
+	segs := strings.Split(src, "/")
+	name, groups := segs[len(segs)-1], segs[:len(segs)-1]
+	dispName, note, tm, addYM := "", "", time.Now(), imp.addYM
+	if item, ok := imp.items[src]; ok {
+		name, dispName, groups, note, tm = item.Origin, item.Name, item.Groups, item.Note, item.Time
+		addYM = len(segs) > 1 && rYM.MatchString(segs[0])
+		if name == "" {
+			name = item.Name
+		}
+		if tm.IsZero() {
+			tm = time.Now()
+		}
+	}
+	groups = append(append([]string{}, imp.groups...), groups...)
+
+	rc, err := open()
+	if err != nil {
+		rst.Err = err.Error()
+		return
+	}
+	defer rc.Close()
+	fi, err := imp.us.saveFile(rc, name, note, nil, tm, addYM, groups...)
+	if fi != nil {
+		rst.Id, rst.Path = fi.ID(), fi.Path
+		imp.hashes[hash] = fi.ID()
+		if err == nil && dispName != "" && dispName != fi.Name() {
+			err = imp.us.SetFIName(fi.ID(), dispName)
+		}
+	}
+	if err != nil {
+		rst.Err = err.Error()
+	}
+}
+
+// ImportDir imports all files under 'dir', subdirectories are mapped to groups after 'groups'.
+// 'manifest.json' in 'dir', such as from an extracted export, restores groups, notes & time.
+func (us *UserSpace) ImportDir(dir string, addYM bool, groups ...string) (*ImportReport, error) {
+	imp := us.newImporter(addYM, groups)
+	if data, err := os.ReadFile(filepath.Join(dir, ManifestName)); err == nil {
+		if err := imp.loadManifest(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); rel == ManifestName {
+			return nil
+		}
+		imp.importFile(rel, func() (io.ReadCloser, error) { return os.Open(p) })
+		return nil
+	})
+	return imp.rpt, err
+}
+
+// ImportZip imports all files in zip archive, directories are mapped to groups after 'groups'.
+// 'manifest.json' in archive, such as from 'Export', restores groups, notes & time.
+func (us *UserSpace) ImportZip(r io.ReaderAt, size int64, addYM bool, groups ...string) (*ImportReport, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	imp := us.newImporter(addYM, groups)
+	for _, f := range zr.File {
+		if f.Name == ManifestName {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = imp.loadManifest(rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, f := range zr.File {
+		name := path.Clean(f.Name)
+		if f.FileInfo().IsDir() || name == ManifestName {
+			continue
+		}
+		if !fs.ValidPath(name) {
+			imp.rpt.add(ImportResult{Source: f.Name, Err: "invalid entry path"})
+			continue
+		}
+		imp.importFile(name, f.Open)
+	}
+	return imp.rpt, nil
+}
+
+// ImportTar imports all files in tar or tar.gz stream, directories are mapped to groups after 'groups'.
+// 'manifest.json' restores groups, notes & time of entries after it, 'Export' writes it as first entry.
+func (us *UserSpace) ImportTar(r io.Reader, addYM bool, groups ...string) (*ImportReport, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	} else {
+		r = br
+	}
+
+	// tar entry can be read only once, spool it for hashing & saving
+	tmp, err := os.CreateTemp("", "filemgr-import-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	imp := us.newImporter(addYM, groups)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imp.rpt, err
+		}
+		name := path.Clean(hdr.Name)
+		switch {
+		case hdr.Typeflag != tar.TypeReg:
+		case name == ManifestName:
+			if err := imp.loadManifest(tr); err != nil {
+				return imp.rpt, err
+			}
+		case !fs.ValidPath(name):
+			imp.rpt.add(ImportResult{Source: hdr.Name, Err: "invalid entry path"})
+		default:
+			if err := spool(tmp, tr); err != nil {
+				return imp.rpt, err
+			}
+			imp.importFile(name, func() (io.ReadCloser, error) {
+				if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+					return nil, err
+				}
+				return io.NopCloser(tmp), nil
+			})
+		}
+	}
+	return imp.rpt, nil
+}
+
+func spool(f *os.File, r io.Reader) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(f, r)
+	return err
+}
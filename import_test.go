@@ -0,0 +1,80 @@
+package filemgr
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+func TestImport(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	src, err := UseUser(fmt.Sprintf("import src %d", time.Now().UnixNano()))
+	lk.FailOnErr("%v", err)
+
+	tm := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	for i, grps := range [][]string{{"G0", "G1"}, {"G2"}} {
+		_, err := src.saveFile(strings.NewReader(fmt.Sprintf("import content %d %v", i, time.Now().UnixNano())), fmt.Sprintf("f%d.txt", i), fmt.Sprintf("note %d", i), nil, tm, true, grps...)
+		lk.FailOnErr("%v", err)
+	}
+
+	tgz, zipped := &bytes.Buffer{}, &bytes.Buffer{}
+	_, err = src.Export(tgz, FmtTarGz, &ExportFilter{From: tm, To: tm.Add(time.Second)})
+	lk.FailOnErr("%v", err)
+	_, err = src.Export(zipped, FmtZip, &ExportFilter{From: tm, To: tm.Add(time.Second)})
+	lk.FailOnErr("%v", err)
+
+	// tar.gz from 'Export', metadata restored from manifest
+	dst, err := UseUser(fmt.Sprintf("import dst %d", time.Now().UnixNano()))
+	lk.FailOnErr("%v", err)
+	rpt, err := dst.ImportTar(tgz, false, "restored")
+	lk.FailOnErr("%v", err)
+	fmt.Printf("%+v\n", rpt)
+	lk.FailOnErrWhen(rpt.Imported != 2 || rpt.Failed != 0, "%v", fmt.Errorf("tar.gz import: %+v", rpt))
+	for _, fi := range dst.FIs {
+		fmt.Println(fi)
+		lk.FailOnErrWhen(!fi.Tm.Equal(tm) || !strings.HasPrefix(fi.Note, "note ") || !strings.HasPrefix(fi.GroupList, "restored^G"), "%v", fmt.Errorf("metadata lost: %v", fi))
+		lk.FailOnErrWhen(!strings.Contains(fi.Path, "2021-03"), "%v", fmt.Errorf("month lost: %v", fi.Path))
+	}
+
+	// same content again, de-duplicated
+	rpt, err = dst.ImportZip(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()), false)
+	lk.FailOnErr("%v", err)
+	fmt.Printf("%+v\n", rpt)
+	lk.FailOnErrWhen(rpt.Skipped != 2 || rpt.Imported != 0, "%v", fmt.Errorf("zip import: %+v", rpt))
+
+	// plain directory tree, subdirectories are groups
+	dir := t.TempDir()
+	lk.FailOnErr("%v", os.MkdirAll(filepath.Join(dir, "A", "B"), os.ModePerm))
+	lk.FailOnErr("%v", os.WriteFile(filepath.Join(dir, "A", "B", "x.txt"), []byte(fmt.Sprint("x", time.Now().UnixNano())), 0o644))
+	lk.FailOnErr("%v", os.WriteFile(filepath.Join(dir, "y.txt"), []byte(fmt.Sprint("y", time.Now().UnixNano())), 0o644))
+	lk.FailOnErr("%v", os.WriteFile(filepath.Join(dir, "A", "y.txt"), []byte(fmt.Sprint("y", time.Now().UnixNano())), 0o644))
+	rpt, err = dst.ImportDir(dir, true)
+	lk.FailOnErr("%v", err)
+	fmt.Printf("%+v\n", rpt)
+	lk.FailOnErrWhen(rpt.Imported != 3, "%v", fmt.Errorf("dir import: %+v", rpt))
+	lk.FailOnErrWhen(len(dst.SearchFileItem("any", "A", "B")) != 1, "%v", fmt.Errorf("groups of dir import"))
+
+	// manifest groups MUST NOT escape user space, missing time is now
+	dir = t.TempDir()
+	lk.FailOnErr("%v", os.WriteFile(filepath.Join(dir, "evil.txt"), []byte(fmt.Sprint("evil", time.Now().UnixNano())), 0o644))
+	lk.FailOnErr("%v", os.WriteFile(filepath.Join(dir, "ok.txt"), []byte(fmt.Sprint("ok", time.Now().UnixNano())), 0o644))
+	lk.FailOnErr("%v", os.WriteFile(filepath.Join(dir, ManifestName), []byte(`{"items":[
+		{"file":"evil.txt","original":"evil.txt","groups":["..","victim","planted"]},
+		{"file":"ok.txt","original":"ok.txt","groups":["G9"]}]}`), 0o644))
+	rpt, err = dst.ImportDir(dir, false)
+	lk.FailOnErr("%v", err)
+	fmt.Printf("%+v\n", rpt)
+	lk.FailOnErrWhen(rpt.Imported != 1 || rpt.Failed != 1, "%v", fmt.Errorf("traversal import: %+v", rpt))
+	lk.FailOnErrWhen(fd.DirExists(filepath.Join(filepath.Dir(dst.UserPath), "victim")), "%v", fmt.Errorf("file planted out of user space"))
+	ok := dst.SearchFileItem("any", "G9")
+	lk.FailOnErrWhen(len(ok) != 1 || time.Since(ok[0].Tm) > time.Minute, "%v", fmt.Errorf("missing time MUST be now: %v", ok))
+}
@@ -0,0 +1,335 @@
+package filemgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+const (
+	JobProcess   = "process"   // args: ProcessOptions, crop, resize, rotate & transcode
+	JobThumbnail = "thumbnail" // args: thumbnail sizes
+	JobTranscode = "transcode" // args: renditions
+	JobMeta      = "meta"      // args: none, metadata of saved file
+)
+
+// runs one job on 'fi' loaded from DB, 'us' is NOT loaded, only for paths.
+// 'progress' reports percentage 0-100 of long running job.
+type jobRunner func(us *UserSpace, fi *fdb.FileItem, args string, progress func(pct float64)) error
+
+var jobRunners = map[string]jobRunner{
+	JobProcess:   runProcessJob,
+	JobThumbnail: runThumbnailJob,
+	JobTranscode: runTranscodeJob,
+	JobHLS:       runHLSJob,
+	JobMeta:      runMetaJob,
+}
+
+var workers = struct {
+	sync.Mutex
+	wake   chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	purged time.Time // last purge of finished jobs
+}{}
+
+func jobsRunning() bool {
+	workers.Lock()
+	defer workers.Unlock()
+	return workers.stop != nil
+}
+
+// StartJobs starts 'n' workers running queued jobs in background, jobs interrupted last time are resumed.
+// While workers are running, SaveFile queues processing & thumbnails instead of running them inline.
+func StartJobs(n int) error {
+	workers.Lock()
+	defer workers.Unlock()
+
+	if workers.stop != nil {
+		return errors.New("job workers are already running")
+	}
+	if n <= 0 {
+		return fmt.Errorf("number of job workers [%d] MUST be positive", n)
+	}
+	if _, err := fdb.ResetRunningJobs(); err != nil {
+		return err
+	}
+	workers.wake = make(chan struct{}, n)
+	workers.stop = make(chan struct{})
+	for i := 0; i < n; i++ {
+		workers.wg.Add(1)
+		go work(workers.wake, workers.stop)
+	}
+	return nil
+}
+
+// StopJobs stops workers after their current jobs finish, queued jobs stay in DB
+func StopJobs() {
+	workers.Lock()
+	stop := workers.stop
+	workers.stop = nil
+	workers.Unlock()
+
+	if stop != nil {
+		close(stop)
+		workers.wg.Wait()
+	}
+}
+
+func notifyJobs() {
+	workers.Lock()
+	defer workers.Unlock()
+	select {
+	case workers.wake <- struct{}{}:
+	default:
+	}
+}
+
+func work(wake, stop <-chan struct{}) {
+	defer workers.wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		job, err := fdb.ClaimJob()
+		lk.WarnOnErr("%v", err)
+		if job != nil {
+			runJob(job)
+			continue
+		}
+		purgeJobs()
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		case <-time.After(time.Second): // retries become due
+		}
+	}
+}
+
+// remove jobs finished 'OptJobKeep' ago, at most once a minute
+func purgeJobs() {
+	workers.Lock()
+	due := time.Since(workers.purged) >= time.Minute
+	if due {
+		workers.purged = time.Now()
+	}
+	workers.Unlock()
+	if due {
+		_, err := fdb.PurgeJobs(time.Now().Add(-opt.jobKeep))
+		lk.WarnOnErr("%v", err)
+	}
+}
+
+func runJob(job *fdb.Job) {
+	err := func() error {
+		run, ok := jobRunners[job.Kind]
+		if !ok {
+			return fmt.Errorf("%w: job kind [%s] is unsupported", ErrInvalid, job.Kind)
+		}
+		fi, ok, err := fdb.FirstFileItem(job.FiId)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("[%s] %w", job.FiId, os.ErrNotExist)
+		}
+		// owner from path, FileItem may be transferred or its user renamed after queueing
+		return run(ownerSpace(fi), fi, job.Args, func(pct float64) {
+			if p := int(pct); p > job.Progress {
+				job.Progress, job.Updated = p, time.Now()
+				lk.WarnOnErr("%v", fdb.UpdateJob(job))
+			}
+		})
+	}()
+
+	job.Tries++
+	job.Updated = time.Now()
+	job.Err = ""
+	switch {
+	case err == nil:
+		job.Status, job.Progress = fdb.JobDone, 100
+	case job.Tries < opt.jobTries && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, ErrInvalid):
+		job.Status = fdb.JobPending
+		job.NotBefore = job.Updated.Add(opt.jobRetryDelay * time.Duration(job.Tries))
+	default:
+		job.Status = fdb.JobFailed
+	}
+	if err != nil {
+		job.Err = err.Error()
+	}
+	lk.WarnOnErr("%v", fdb.UpdateJob(job))
+}
+
+func runProcessJob(us *UserSpace, fi *fdb.FileItem, args string, progress func(pct float64)) error {
+	po := &ProcessOptions{}
+	if err := json.Unmarshal([]byte(args), po); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	po, err := po.resolve(us)
+	if err != nil {
+		return err
+	}
+	p, err := process(fi.Path, fi.Type(), po)
+	if err != nil {
+		if !fi.HasMeta() { // file stays as uploaded
+			lk.WarnOnErr("%v", storeMeta(fi))
+		}
+		return err
+	}
+	if err := newVersion(fi, p); err != nil {
+		return err
+	}
+	if opt.thumbOnSave {
+		return us.renderThumbs(fi)
+	}
+	return nil
+}
+
+func runThumbnailJob(us *UserSpace, fi *fdb.FileItem, args string, progress func(pct float64)) error {
+	sizes := []int{}
+	if err := json.Unmarshal([]byte(args), &sizes); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return us.renderThumbs(fi, sizes...)
+}
+
+func runMetaJob(us *UserSpace, fi *fdb.FileItem, args string, progress func(pct float64)) error {
+	return storeMeta(fi)
+}
+
+func (us *UserSpace) queue(fi *fdb.FileItem, kind string, args any) (*fdb.Job, error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	job, err := fdb.AddJob(us.UName, fi.Id, kind, string(data))
+	if err != nil {
+		return nil, err
+	}
+	notifyJobs()
+	return job, nil
+}
+
+// processing also renders thumbnails & refreshes metadata after it
+func (us *UserSpace) queueOnSave(fi *fdb.FileItem, po *ProcessOptions) (err error) {
+	media := In(fi.Type(), fd.Image, fd.Video)
+	if !media || po.isZero() {
+		if _, err = us.queue(fi, JobMeta, nil); err != nil || !media {
+			return err
+		}
+	}
+	switch {
+	case !po.isZero():
+		_, err = us.queue(fi, JobProcess, po)
+	case opt.thumbOnSave:
+		_, err = us.queue(fi, JobThumbnail, opt.thumbSizes)
+	}
+	return
+}
+
+// QueueProcess queues processing of image or video FileItems matching 'id', FileItem in DB is updated when done
+func (us *UserSpace) QueueProcess(id string, po *ProcessOptions) (jobs []*fdb.Job, err error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		if NotIn(fi.Type(), fd.Image, fd.Video) {
+			return jobs, fmt.Errorf("[%s] is %s, only image & video can be processed", fi.Id, fi.Type())
+		}
+		job, err := us.queue(fi, JobProcess, po)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// QueueThumbnails queues rendering thumbnails of 'sizes' of FileItems matching 'id', no 'sizes' for 'OptThumbSizes'
+func (us *UserSpace) QueueThumbnails(id string, sizes ...int) (jobs []*fdb.Job, err error) {
+	if len(sizes) == 0 {
+		sizes = opt.thumbSizes
+	}
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		job, err := us.queue(fi, JobThumbnail, sizes)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Jobs lists jobs of FileItems matching 'id' in queueing order, with status & error
+func (us *UserSpace) Jobs(id string) ([]*fdb.Job, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		ids = append(ids, fi.Id)
+	}
+	return fdb.ListJobs(func(job *fdb.Job) bool { return In(job.FiId, ids...) })
+}
+
+// RetryJob queues failed job again with a fresh number of tries
+func RetryJob(jobId string) (*fdb.Job, error) {
+	job, err := fdb.GetJob(jobId)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, fmt.Errorf("job [%s] does NOT exist", jobId)
+	}
+	if job.Status != fdb.JobFailed {
+		return nil, fmt.Errorf("job [%s] is %s, only failed job can be retried", jobId, job.Status)
+	}
+	job.Status, job.Tries, job.Updated, job.NotBefore = fdb.JobPending, 0, time.Now(), time.Now()
+	if err := fdb.UpdateJob(job); err != nil {
+		return nil, err
+	}
+	notifyJobs()
+	return job, nil
+}
+
+// Reload reloads FileItems from DB, such as after background jobs have updated them
+func (us *UserSpace) Reload() error {
+	us.IDs = make(map[string]struct{})
+	_, err := us.loadFI(false)
+	return err
+}
+
+// remove queued & finished jobs of 'fi', running one fails by itself
+func dropJobs(fi *fdb.FileItem) error {
+	_, err := fdb.DeleteJobs(func(job *fdb.Job) bool {
+		return job.FiId == fi.Id && job.Status != fdb.JobRunning
+	})
+	return err
+}
+
+// display name of 'name' converted to format of 'path', empty if format is unchanged
+func convertedName(name, path string) string {
+	if ext := filepath.Ext(path); ext != filepath.Ext(name) {
+		return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+	}
+	return ""
+}
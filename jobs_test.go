@@ -0,0 +1,114 @@
+package filemgr
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+// wait until every job of 'id' finishes
+func waitJobs(us *UserSpace, id string) []*fdb.Job {
+	for i := 0; i < 100; i++ {
+		jobs, err := us.Jobs(id)
+		lk.FailOnErr("%v", err)
+		done := true
+		for _, job := range jobs {
+			done = done && (job.Status == fdb.JobDone || job.Status == fdb.JobFailed)
+		}
+		if done {
+			return jobs
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	lk.FailOnErr("%v", fmt.Errorf("jobs of [%s] are not finished", id))
+	return nil
+}
+
+func TestJobs(t *testing.T) {
+
+	InitFileMgr("./data")
+	OptJobRetry(2, 10*time.Millisecond)
+	defer OptJobRetry(3, 10*time.Second)
+
+	lk.FailOnErr("%v", StartJobs(2))
+	defer StopJobs()
+	lk.FailOnErrWhen(StartJobs(1) == nil, "%v", fmt.Errorf("workers started twice"))
+
+	us, err := UseUser("job test")
+	lk.FailOnErr("%v", err)
+
+	// saved as uploaded, processed in background
+	po := &ProcessOptions{Crop: &Rect{0, 0, 200, 100}, Format: "jpg"}
+	path, err := us.SaveFile(testPNG(400, 300), "queued.png", "", po, false, "album")
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(filepath.Ext(path) != ".png", "%v", fmt.Errorf("processed inline: %s", path))
+	fi := us.FIs[len(us.FIs)-1]
+
+	jobs := waitJobs(us, fi.ID())
+	fmt.Println(jobs)
+	lk.FailOnErrWhen(len(jobs) != 1 || jobs[0].Status != fdb.JobDone, "%v", fmt.Errorf("jobs: %v", jobs))
+
+	// edits without reloading MUST NOT write stale path back
+	lk.FailOnErr("%v", us.SetFINote(fi.ID(), "processed"))
+	stored, ok, err := fdb.FirstFileItem(fi.ID())
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(!ok || stored.Path != fi.Path || !fd.FileExists(stored.Path) || stored.Note != "processed", "%v", fmt.Errorf("stale edit: %v %v", stored, fi))
+	fis, err := us.FileItems(fi.ID())
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(fis) != 1 || filepath.Ext(fis[0].Path) != ".jpg" || fis[0].Name() != "queued.jpg", "%v", fmt.Errorf("not updated: %v", fis))
+	lk.FailOnErrWhen(fd.FileExists(path), "%v", fmt.Errorf("original remains: %s", path))
+	img, err := loadImage(fis[0].Path)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(img.Bounds().Dx() != 200 || img.Bounds().Dy() != 100, "%v", fmt.Errorf("bounds: %v", img.Bounds()))
+	lk.FailOnErrWhen(!thumbFresh(fis[0], us.thumbPath(fis[0], 128)), "%v", fmt.Errorf("thumbnail is not rendered"))
+
+	// metadata is extracted in background
+	lk.FailOnErrWhen(!stored.HasMeta() || stored.Meta.Width != 200, "%v", fmt.Errorf("meta of new version: %v", stored.Meta))
+	_, err = us.SaveFile(testPNG(40, 30), "plain.png", "", nil, false, "album")
+	lk.FailOnErr("%v", err)
+	plain := us.FIs[len(us.FIs)-1]
+	jobs = waitJobs(us, plain.ID())
+	lk.FailOnErrWhen(len(jobs) == 0 || jobs[0].Kind != JobMeta, "%v", fmt.Errorf("jobs: %v", jobs))
+	lk.FailOnErr("%v", us.syncMemFI(plain))
+	lk.FailOnErrWhen(!plain.HasMeta() || plain.Meta.Width != 40, "%v", fmt.Errorf("meta: %v", plain.Meta))
+
+	// invalid options fail at once, file stays as uploaded
+	_, err = us.SaveFile(testPNG(40, 30), "bad.png", "", &ProcessOptions{Crop: &Rect{0, 0, 50, 50}}, false, "album")
+	lk.FailOnErr("%v", err)
+	bad := us.FIs[len(us.FIs)-1]
+	jobs = waitJobs(us, bad.ID())
+	fmt.Println(jobs)
+	lk.FailOnErrWhen(len(jobs) != 1 || jobs[0].Status != fdb.JobFailed || jobs[0].Tries != 1 || jobs[0].Err == "", "%v", fmt.Errorf("jobs: %v", jobs))
+	lk.FailOnErrWhen(!fd.FileExists(bad.Path), "%v", fmt.Errorf("uploaded file is lost"))
+
+	job, err := RetryJob(jobs[0].Id)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(job.Status != fdb.JobPending, "%v", fmt.Errorf("retry: %v", job))
+	waitJobs(us, bad.ID())
+
+	// finished jobs are purged after kept time
+	OptJobKeep(0)
+	defer OptJobKeep(24 * time.Hour)
+	workers.Lock()
+	workers.purged = time.Time{}
+	workers.Unlock()
+	purgeJobs()
+	left, err := fdb.ListJobs(func(job *fdb.Job) bool { return job.User == us.UName })
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(left) != 0, "%v", fmt.Errorf("finished jobs left: %v", left))
+
+	// deleting FileItem drops its queued jobs
+	_, err = us.QueueThumbnails(bad.ID())
+	lk.FailOnErr("%v", err)
+	for _, fi := range us.FIs {
+		lk.FailOnErr("%v", us.DelFileItem(fi.ID()))
+	}
+	left, err = fdb.ListJobs(func(job *fdb.Job) bool { return job.User == us.UName })
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(left) != 0, "%v", fmt.Errorf("jobs left: %v", left))
+}
@@ -7,8 +7,12 @@ import (
 	"image/jpeg"
 	"image/png"
 	"os"
+	"os/exec"
+	"strconv"
+	"time"
 
 	"github.com/jtguibas/cinema"
+	xdraw "golang.org/x/image/draw"
 )
 
 func loadImage(path string) (image.Image, error) {
@@ -39,7 +43,25 @@ func roi4rgba(img image.Image, left, top, right, bottom int) *image.RGBA {
 	return rgba
 }
 
-func saveJPG(img image.Image, path string) (image.Image, error) {
+// scale down 'img', longest edge becomes 'size', smaller image is only copied
+func fitImage(img image.Image, size int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w >= h && w > size {
+		w, h = size, max(1, h*size/w)
+	} else if h > w && h > size {
+		w, h = max(1, w*size/h), size
+	}
+	return resizeImage(img, w, h)
+}
+
+// scale 'img' to 'w' x 'h' by Catmull-Rom resampling
+func resizeImage(img image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func saveJPG(img image.Image, path string, quality int) (image.Image, error) {
 	out, err := os.Create(path)
 	if err != nil {
 		return nil, err
@@ -47,7 +69,7 @@ func saveJPG(img image.Image, path string) (image.Image, error) {
 	defer out.Close()
 
 	var opts jpeg.Options
-	opts.Quality = 100
+	opts.Quality = quality
 	if err := jpeg.Encode(out, img, &opts); err != nil {
 		return nil, err
 	}
@@ -67,13 +89,18 @@ func savePNG(img image.Image, path string) (image.Image, error) {
 	return img, nil
 }
 
-// return "width,height"
+// return "width,height", only header is decoded. FileItem.Dimensions has it without reading file
 func GetImageSize(fPath string) (string, error) {
-	img, err := loadImage(fPath)
-	if err != nil || img == nil {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%d,%d", img.Bounds().Dx(), img.Bounds().Dy()), nil
+	return fmt.Sprintf("%d,%d", cfg.Width, cfg.Height), nil
 }
 
 // sudo apt install ffmpeg
@@ -87,3 +114,19 @@ func GetVideoSize(fPath string) (string, error) {
 	}
 	return fmt.Sprintf("%d,%d", video.Width(), video.Height()), nil
 }
+
+// extract one frame at 'at' of video to image file 'out', such as "poster.png"
+func videoFrame(fPath, out string, at time.Duration) error {
+	video, err := cinema.Load(fPath)
+	if err != nil {
+		return err
+	}
+	if at >= video.Duration() {
+		at = video.Duration() / 2
+	}
+	ss := strconv.FormatFloat(at.Seconds(), 'f', -1, 64)
+	if output, err := exec.Command("ffmpeg", "-y", "-ss", ss, "-i", fPath, "-frames:v", "1", out).CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v, %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package filemgr
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+// sniff MIME from head of content, generic result falls back to extension
+func sniffMIME(fPath string, head []byte) string {
+	ct, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	if In(ct, "application/octet-stream", "application/zip", "text/plain") {
+		if byExt, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(fPath)), ";"); byExt != "" {
+			return byExt
+		}
+	}
+	return ct
+}
+
+// sudo apt install ffmpeg
+// streams, duration & tags of audio or video by ffprobe into 'meta', tags already known are kept
+func probeMedia(fPath string, meta *fdb.Meta) error {
+	out, err := exec.Command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", fPath).Output()
+	if err != nil {
+		return fmt.Errorf("ffprobe failed: %v", err)
+	}
+	desc := struct {
+		Streams []struct {
+			CodecType  string `json:"codec_type"`
+			CodecName  string `json:"codec_name"`
+			Width      int    `json:"width"`
+			Height     int    `json:"height"`
+			SampleRate string `json:"sample_rate"`
+			Channels   int    `json:"channels"`
+		} `json:"streams"`
+		Format struct {
+			Duration string            `json:"duration"`
+			BitRate  string            `json:"bit_rate"`
+			Tags     map[string]string `json:"tags"`
+		} `json:"format"`
+	}{}
+	if err := json.Unmarshal(out, &desc); err != nil {
+		return fmt.Errorf("ffprobe output: %v", err)
+	}
+	codec := ""
+	for _, s := range desc.Streams {
+		if s.Width > 0 && meta.Width == 0 {
+			meta.Width, meta.Height = s.Width, s.Height
+			if s.CodecName != "" {
+				codec = s.CodecName
+			}
+		}
+		if codec == "" && s.CodecName != "" && s.CodecType != "video" {
+			codec = s.CodecName
+		}
+		if s.CodecType == "audio" && meta.SampleRate == 0 {
+			meta.SampleRate, _ = strconv.Atoi(s.SampleRate)
+			meta.Channels = s.Channels
+		}
+	}
+	meta.Codec = codec
+	meta.Duration, _ = strconv.ParseFloat(desc.Format.Duration, 64)
+	meta.Bitrate, _ = strconv.Atoi(desc.Format.BitRate)
+
+	tags := map[string]string{}
+	for k, v := range desc.Format.Tags {
+		tags[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	setTags(meta, tags["title"], tags["artist"], tags["album"], tags["date"], tags["genre"], tags["track"])
+	return nil
+}
+
+var rPdfPage = regexp.MustCompile(`/Type\s*/Page([^s]|$)`)
+
+// count page objects in first 'maxPDF' bytes of PDF without parsing, compressed object streams are missed
+func pdfPages(fPath string) int {
+	data, err := readHead(fPath, maxPDF)
+	if err != nil {
+		return 0
+	}
+	return pdfPageCount(data)
+}
+
+func pdfPageCount(data []byte) int {
+	return len(rPdfPage.FindAll(data, -1))
+}
+
+// extract metadata of file 'fPath' of 'fType'. Content part failing, such as missing ffprobe,
+// is returned with error, while Size, MIME & Hash are always filled.
+func extractMeta(fPath, fType string) (meta fdb.Meta, err error) {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return meta, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	meta.MIME = sniffMIME(fPath, head[:n])
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return meta, err
+	}
+	h := md5.New()
+	if meta.Size, err = io.Copy(h, f); err != nil {
+		return meta, err
+	}
+	meta.Hash = fmt.Sprintf("%x", h.Sum(nil))
+
+	switch {
+	case fType == fd.Image:
+		if err := extractExif(fPath, &meta); err != nil {
+			return meta, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return meta, err
+		}
+		cfg, format, err := image.DecodeConfig(f) // header only
+		if err != nil {
+			return meta, err
+		}
+		meta.Width, meta.Height, meta.Codec = cfg.Width, cfg.Height, format
+		if a, err := loadAnimation(fPath); err == nil && a != nil {
+			meta.Frames, meta.Duration = len(a.frames), a.duration().Seconds()
+		}
+		img, err := loadImage(fPath)
+		if err != nil {
+			return meta, err
+		}
+		meta.AHash, meta.DHash, meta.PHash = hashHex(aHash(img)), hashHex(dHash(img)), hashHex(pHash(img))
+		meta.Blurhash, meta.Color = blurhash(img, blurhashX, blurhashY), dominantColor(img)
+	case fType == fd.Audio:
+		return meta, extractAudio(fPath, &meta)
+	case fType == fd.Video:
+		return meta, probeMedia(fPath, &meta)
+	case meta.MIME == "application/pdf":
+		meta.Pages = pdfPages(fPath)
+	}
+	return meta, nil
+}
+
+// refresh metadata of 'fi' from its content, failure is only warned
+func refreshMeta(fi *fdb.FileItem) {
+	meta, err := extractMeta(fi.Path, fi.Type())
+	lk.WarnOnErr("metadata of [%s]: %v", fi.Id, err)
+	if meta.Hash != "" {
+		lk.WarnOnErr("text of [%s]: %v", fi.Id, ownerSpace(fi).extractText(fi, &meta))
+		fi.Meta = meta
+	}
+}
+
+// MigrateMeta back-fills metadata of all users' FileItems saved before metadata existed,
+// or of all FileItems if 'force'. Loaded UserSpaces need 'Reload' to see them.
+func MigrateMeta(force bool) (int, error) {
+	fis, err := fdb.ListFileItems(func(fi *fdb.FileItem) bool { return force || !fi.HasMeta() })
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, fi := range fis {
+		if !fd.FileExists(fi.Path) {
+			continue // see 'Reconcile'
+		}
+		if err := storeMeta(fi); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// refresh metadata of 'fi' & its DB record, unless a new version replaced it meanwhile
+func storeMeta(fi *fdb.FileItem) error {
+	refreshMeta(fi)
+	_, err := fdb.EditFileItem(fi.Id, func(cur *fdb.FileItem) error {
+		if cur.Path == fi.Path { // otherwise replaced meanwhile, with its own metadata
+			cur.Meta = fi.Meta
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,63 @@
+package filemgr
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	lk "github.com/digisan/logkit"
+)
+
+func TestMetadata(t *testing.T) {
+
+	fakeFFmpeg(t)
+	InitFileMgr("./data")
+	OptThumbOnSave(false)
+	defer OptThumbOnSave(true)
+
+	us, err := UseUser("meta test")
+	lk.FailOnErr("%v", err)
+
+	_, err = us.SaveFile(testPNG(40, 30), "dot.png", "", nil, false, "meta")
+	lk.FailOnErr("%v", err)
+	img := us.FIs[len(us.FIs)-1]
+	fmt.Println(img.Meta)
+	w, h := img.Dimensions()
+	lk.FailOnErrWhen(w != 40 || h != 30 || img.MIME() != "image/png" || img.Codec() != "png", "%v", fmt.Errorf("image meta %v", img.Meta))
+	lk.FailOnErrWhen(img.Size() == 0 || !strings.HasPrefix(img.Id, img.Hash()), "%v", fmt.Errorf("size & hash %v", img.Meta))
+
+	_, err = us.SaveFile(bytes.NewReader(largeMedia(1<<16)), "clip.mp4", "", nil, false, "meta")
+	lk.FailOnErr("%v", err)
+	video := us.FIs[len(us.FIs)-1]
+	fmt.Println(video.Meta)
+	lk.FailOnErrWhen(video.Duration() != 10*time.Second || video.Meta.Width != 1280, "%v", fmt.Errorf("video meta %v", video.Meta))
+
+	pdf := "%PDF-1.4\n1 0 obj << /Type /Pages /Count 2 >> endobj\n2 0 obj << /Type /Page >> endobj\n3 0 obj << /Type/Page >> endobj\n%%EOF\n"
+	_, err = us.SaveFile(strings.NewReader(pdf), "two.pdf", "", nil, false, "meta")
+	lk.FailOnErr("%v", err)
+	doc := us.FIs[len(us.FIs)-1]
+	fmt.Println(doc.Meta)
+	lk.FailOnErrWhen(doc.Pages() != 2 || doc.MIME() != "application/pdf", "%v", fmt.Errorf("pdf meta %v", doc.Meta))
+
+	// new version refreshes metadata
+	_, err = us.TransformImage(img.Id, &ImageTransform{Rotate: 90}, false)
+	lk.FailOnErr("%v", err)
+	w, h = img.Dimensions()
+	lk.FailOnErrWhen(w != 30 || h != 40, "%v", fmt.Errorf("rotated meta %v", img.Meta))
+
+	// items saved before metadata are back-filled
+	img.Meta = fdb.Meta{}
+	lk.FailOnErr("%v", us.UpdateFileItem(img, false))
+	n, err := MigrateMeta(false)
+	lk.FailOnErr("%v", err)
+	fmt.Println("migrated:", n)
+	lk.FailOnErrWhen(n < 1, "%v", fmt.Errorf("nothing migrated"))
+	lk.FailOnErr("%v", us.Reload())
+	fis, err := us.FileItems(img.Id)
+	lk.FailOnErr("%v", err)
+	w, h = fis[0].Dimensions()
+	lk.FailOnErrWhen(!fis[0].HasMeta() || w != 30 || h != 40, "%v", fmt.Errorf("migrated meta %v", fis[0].Meta))
+}
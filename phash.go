@@ -0,0 +1,226 @@
+package filemgr
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+)
+
+// luminance of 'img' scaled to 'w' x 'h'
+func grayPixels(img image.Image, w, h int) []float64 {
+	small := resizeImage(img, w, h)
+	px := make([]float64, 0, w*h)
+	for i := 0; i < len(small.Pix); i += 4 {
+		r, g, b := float64(small.Pix[i]), float64(small.Pix[i+1]), float64(small.Pix[i+2])
+		px = append(px, 0.299*r+0.587*g+0.114*b)
+	}
+	return px
+}
+
+// average hash, bit is set where 8x8 pixel is brighter than mean
+func aHash(img image.Image) uint64 {
+	px := grayPixels(img, 8, 8)
+	mean := 0.0
+	for _, p := range px {
+		mean += p / 64
+	}
+	hash := uint64(0)
+	for i, p := range px {
+		if p > mean {
+			hash |= 1 << (63 - i)
+		}
+	}
+	return hash
+}
+
+// difference hash, bit is set where pixel is brighter than its right neighbour in 9x8
+func dHash(img image.Image) uint64 {
+	px := grayPixels(img, 9, 8)
+	hash, i := uint64(0), 0
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if px[y*9+x] > px[y*9+x+1] {
+				hash |= 1 << (63 - i)
+			}
+			i++
+		}
+	}
+	return hash
+}
+
+// 1D DCT-II of 'in'
+func dct(in []float64) []float64 {
+	n := len(in)
+	out := make([]float64, n)
+	for k := range out {
+		sum := 0.0
+		for i, v := range in {
+			sum += v * math.Cos(math.Pi/float64(n)*(float64(i)+0.5)*float64(k))
+		}
+		out[k] = sum
+	}
+	return out
+}
+
+// perceptual hash, bit is set where low frequency of 32x32 DCT is above median, DC excluded
+func pHash(img image.Image) uint64 {
+	const n = 32
+	px := grayPixels(img, n, n)
+	rows := make([][]float64, n)
+	for y := range rows {
+		rows[y] = dct(px[y*n : (y+1)*n])
+	}
+	coef := make([]float64, 64) // top-left 8x8 of 2D DCT, row major
+	for x := 0; x < 8; x++ {
+		col := make([]float64, n)
+		for y := range col {
+			col[y] = rows[y][x]
+		}
+		for y, v := range dct(col)[:8] {
+			coef[y*8+x] = v
+		}
+	}
+	sorted := append([]float64{}, coef[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+	hash := uint64(0)
+	for i, c := range coef {
+		if i > 0 && c > median {
+			hash |= 1 << (63 - i)
+		}
+	}
+	return hash
+}
+
+func hashHex(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+// BK-tree of 64 bits hashes by Hamming distance
+type bkNode struct {
+	hash     uint64
+	fis      []*fdb.FileItem
+	children map[int]*bkNode
+}
+
+func (n *bkNode) add(hash uint64, fi *fdb.FileItem) {
+	for {
+		d := bits.OnesCount64(n.hash ^ hash)
+		if d == 0 {
+			n.fis = append(n.fis, fi)
+			return
+		}
+		child, ok := n.children[d]
+		if !ok {
+			n.children[d] = &bkNode{hash: hash, fis: []*fdb.FileItem{fi}, children: map[int]*bkNode{}}
+			return
+		}
+		n = child
+	}
+}
+
+// FileItems of hashes within 'threshold' of 'hash'
+func (n *bkNode) find(hash uint64, threshold int) (fis []*fdb.FileItem) {
+	d := bits.OnesCount64(n.hash ^ hash)
+	if d <= threshold {
+		fis = append(fis, n.fis...)
+	}
+	for cd, child := range n.children {
+		if cd >= d-threshold && cd <= d+threshold {
+			fis = append(fis, child.find(hash, threshold)...)
+		}
+	}
+	return fis
+}
+
+func fiPHash(fi *fdb.FileItem) (uint64, bool) {
+	if fi.Meta.PHash == "" {
+		return 0, false
+	}
+	h, err := strconv.ParseUint(fi.Meta.PHash, 16, 64)
+	return h, err == nil
+}
+
+// index of image FileItems by perceptual hash, nil if none is hashed
+func (us *UserSpace) phashIndex() *bkNode {
+	var root *bkNode
+	for _, fi := range us.FIs {
+		h, ok := fiPHash(fi)
+		if !ok || fi.Type() != fd.Image {
+			continue
+		}
+		if root == nil {
+			root = &bkNode{hash: h, fis: []*fdb.FileItem{fi}, children: map[int]*bkNode{}}
+			continue
+		}
+		root.add(h, fi)
+	}
+	return root
+}
+
+// Similar lists other images whose perceptual hash is within Hamming distance 'threshold' bits
+// of first image FileItem matching 'id', such as resized or recompressed copies. 10 is a usual threshold.
+func (us *UserSpace) Similar(id string, threshold int) ([]*fdb.FileItem, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) == 0 {
+		return nil, fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	h, ok := fiPHash(fis[0])
+	if !ok {
+		return nil, fmt.Errorf("[%s] has no perceptual hash, only image has, see 'MigrateMeta'", id)
+	}
+	similar := []*fdb.FileItem{}
+	for _, fi := range us.phashIndex().find(h, threshold) {
+		if fi != fis[0] {
+			similar = append(similar, fi)
+		}
+	}
+	sort.SliceStable(similar, func(i, j int) bool { return similar[i].Tm.Before(similar[j].Tm) })
+	return similar, nil
+}
+
+// NearDuplicates groups images whose perceptual hashes are linked within 'threshold' bits,
+// only clusters of more than one image are returned, each is ordered by saving time
+func (us *UserSpace) NearDuplicates(threshold int) [][]*fdb.FileItem {
+	root := us.phashIndex()
+	if root == nil {
+		return nil
+	}
+	seen := map[*fdb.FileItem]bool{}
+	dups := [][]*fdb.FileItem{}
+	for _, fi := range us.FIs {
+		if _, ok := fiPHash(fi); !ok || seen[fi] || fi.Type() != fd.Image {
+			continue
+		}
+		// breadth first over linked hashes
+		seen[fi] = true
+		group := []*fdb.FileItem{fi}
+		for i := 0; i < len(group); i++ {
+			h, _ := fiPHash(group[i])
+			for _, near := range root.find(h, threshold) {
+				if !seen[near] {
+					seen[near] = true
+					group = append(group, near)
+				}
+			}
+		}
+		if len(group) > 1 {
+			sort.SliceStable(group, func(i, j int) bool { return group[i].Tm.Before(group[j].Tm) })
+			dups = append(dups, group)
+		}
+	}
+	sort.SliceStable(dups, func(i, j int) bool { return dups[i][0].Tm.Before(dups[j][0].Tm) })
+	return dups
+}
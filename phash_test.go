@@ -0,0 +1,68 @@
+package filemgr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	. "github.com/digisan/go-generics"
+	lk "github.com/digisan/logkit"
+)
+
+// smooth pattern of 'freq', scaled to 'w' x 'h' as jpeg of 'quality'
+func testPattern(w, h int, freq float64, quality int) *bytes.Buffer {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := 127 + 127*math.Sin(freq*fx*math.Pi)*math.Cos(freq*fy*fx*math.Pi)
+			img.Set(x, y, color.RGBA{uint8(v), uint8(255 - v), uint8(v / 2), 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	lk.FailOnErr("%v", jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}))
+	return buf
+}
+
+func TestNearDuplicates(t *testing.T) {
+
+	InitFileMgr("./data")
+	OptThumbOnSave(false)
+	defer OptThumbOnSave(true)
+
+	us, err := UseUser("phash test")
+	lk.FailOnErr("%v", err)
+
+	_, err = us.SaveFile(testPattern(400, 300, 3, 95), "original.jpg", "", nil, false, "dup")
+	lk.FailOnErr("%v", err)
+	ori := us.FIs[len(us.FIs)-1]
+	_, err = us.SaveFile(testPattern(200, 150, 3, 40), "resized.jpg", "", nil, false, "dup")
+	lk.FailOnErr("%v", err)
+	resized := us.FIs[len(us.FIs)-1]
+	_, err = us.SaveFile(testPattern(400, 300, 7, 95), "other.jpg", "", nil, false, "dup")
+	lk.FailOnErr("%v", err)
+	other := us.FIs[len(us.FIs)-1]
+	fmt.Println(ori.Meta.PHash, resized.Meta.PHash, other.Meta.PHash)
+	lk.FailOnErrWhen(ori.Meta.AHash == "" || ori.Meta.DHash == "", "%v", fmt.Errorf("hashes missing %v", ori.Meta))
+
+	similar, err := us.Similar(ori.Id, 10)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(NotIn(resized, similar...) || In(other, similar...), "%v", fmt.Errorf("similar %v", similar))
+
+	found := false
+	for _, group := range us.NearDuplicates(10) {
+		for _, fi := range group {
+			fmt.Print(fi.Name(), " ")
+		}
+		fmt.Println()
+		if In(ori, group...) {
+			found = true
+			lk.FailOnErrWhen(NotIn(resized, group...) || In(other, group...), "%v", fmt.Errorf("cluster %v", group))
+		}
+	}
+	lk.FailOnErrWhen(!found, "%v", fmt.Errorf("cluster of original & resized missing"))
+}
@@ -0,0 +1,224 @@
+package filemgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/digisan/go-generics"
+	fd "github.com/digisan/gotk/file-dir"
+)
+
+const (
+	RejectType     = "type"      // sniffed type is NOT allowed
+	RejectExt      = "extension" // file extension is NOT allowed
+	RejectSize     = "size"      // content exceeds size limit of its type
+	RejectMismatch = "mismatch"  // extension does NOT match sniffed content
+	RejectQuota    = "quota"     // user space would exceed its total size
+
+	policyFile = "policy.json"
+	sniffHead  = 512 // bytes of content head to sniff type
+)
+
+// Policy restricts files accepted by a user space, zero value accepts anything
+type Policy struct {
+	Types    []string         `json:"types,omitempty"`     // allowed types such as "image", "video" of file-dir, empty allows all
+	Exts     []string         `json:"exts,omitempty"`      // allowed extensions such as ".jpg", case insensitive, empty allows all
+	MaxSize  int64            `json:"max_size,omitempty"`  // bytes of any file, 0 is unlimited
+	MaxSizes map[string]int64 `json:"max_sizes,omitempty"` // bytes by type, overriding 'MaxSize'
+	MaxTotal int64            `json:"max_total,omitempty"` // bytes of all files in user space, 0 is unlimited
+	Verify   bool             `json:"verify,omitempty"`    // reject extension mismatching sniffed content, such as script named ".jpg"
+}
+
+// PolicyError is returned for files rejected by upload policy, nothing of them is left on disk
+type PolicyError struct {
+	Name   string // file name
+	Reason string // RejectType, RejectExt, RejectSize, RejectMismatch or RejectQuota
+	Detail string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("[%s] is rejected by policy, %s: %s", e.Name, e.Reason, e.Detail)
+}
+
+func (p *Policy) validate() error {
+	if p.MaxSize < 0 {
+		return fmt.Errorf("max size [%d] is invalid", p.MaxSize)
+	}
+	if p.MaxTotal < 0 {
+		return fmt.Errorf("max total [%d] is invalid", p.MaxTotal)
+	}
+	for t, n := range p.MaxSizes {
+		if n < 0 {
+			return fmt.Errorf("max size [%d] of [%s] is invalid", n, t)
+		}
+	}
+	return nil
+}
+
+// size limit of 'fType', -1 if unlimited
+func (p *Policy) limit(fType string) int64 {
+	if n, ok := p.MaxSizes[fType]; ok && n > 0 {
+		return n
+	}
+	if p.MaxSize > 0 {
+		return p.MaxSize
+	}
+	return -1
+}
+
+func normExt(ext string) string {
+	return strings.ToLower("." + strings.TrimPrefix(ext, "."))
+}
+
+// extension of 'name' claims other kind than sniffed 'fType', such as text named ".jpg" or image named ".txt"
+func mismatched(name, fType string) bool {
+	media := []string{fd.Image, fd.Video, fd.Audio}
+	byExt, _, _ := strings.Cut(mime.TypeByExtension(strings.ToLower(filepath.Ext(name))), ";")
+	major, _, _ := strings.Cut(byExt, "/")
+	switch {
+	case byExt == "":
+		return false // unknown extension claims nothing
+	case strings.HasSuffix(byExt, "+xml"): // text based, such as svg
+		return In(fType, media...)
+	case In(major, media...):
+		return major != fType
+	}
+	return In(fType, media...)
+}
+
+// check 'name' of sniffed 'fType' & 'size', -1 if unknown yet, into user space holding 'used' bytes.
+// return size limit, -1 if unlimited
+func (p *Policy) check(name, fType string, size, used int64) (int64, error) {
+	if len(p.Exts) > 0 {
+		exts := make([]string, 0, len(p.Exts))
+		for _, ext := range p.Exts {
+			exts = append(exts, normExt(ext))
+		}
+		if ext := strings.ToLower(filepath.Ext(name)); NotIn(ext, exts...) {
+			return 0, &PolicyError{name, RejectExt, fmt.Sprintf("[%s] is NOT in %v", ext, exts)}
+		}
+	}
+	if len(p.Types) > 0 && NotIn(fType, p.Types...) {
+		return 0, &PolicyError{name, RejectType, fmt.Sprintf("[%s] is NOT in %v", fType, p.Types)}
+	}
+	if p.Verify && mismatched(name, fType) {
+		return 0, &PolicyError{name, RejectMismatch, fmt.Sprintf("content is %s", fType)}
+	}
+	limit := p.limit(fType)
+	if limit >= 0 && size > limit {
+		return 0, &PolicyError{name, RejectSize, fmt.Sprintf("%d bytes exceeds %d of %s", size, limit, fType)}
+	}
+	if p.MaxTotal > 0 {
+		left := max(0, p.MaxTotal-used)
+		if size > left || (size < 0 && left == 0) {
+			return 0, &PolicyError{name, RejectQuota, fmt.Sprintf("%d bytes left of %d", left, p.MaxTotal)}
+		}
+		if limit < 0 || left < limit {
+			limit = left
+		}
+	}
+	return limit, nil
+}
+
+// default policy of all user spaces, nil accepts anything. user policy set by 'SetPolicy' applies as well
+func OptPolicy(p *Policy) {
+	opt.policy = p
+}
+
+// "root/name/.fi/policy.json"
+func (us *UserSpace) policyPath() string {
+	return filepath.Join(us.UserPath, ArtDir, policyFile)
+}
+
+// Policy returns policy of this user set by 'SetPolicy', nil if none
+func (us *UserSpace) Policy() (*Policy, error) {
+	data, err := os.ReadFile(us.policyPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("policy of [%s]: %w", us.UName, err)
+	}
+	return p, nil
+}
+
+// SetPolicy stores policy of this user in user space, applied together with 'OptPolicy'. nil removes it
+func (us *UserSpace) SetPolicy(p *Policy) error {
+	if p == nil {
+		if err := os.Remove(us.policyPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := p.validate(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	fd.MustCreateDir(filepath.Dir(us.policyPath()))
+	return os.WriteFile(us.policyPath(), data, 0o644)
+}
+
+// check file 'name' of sniffed 'fType' & 'size', -1 if unknown yet, against policies of manager & user.
+// return size limit, -1 if unlimited
+func (us *UserSpace) admit(name, fType string, size, pending int64) (int64, error) {
+	ps := []*Policy{}
+	if opt.policy != nil {
+		ps = append(ps, opt.policy)
+	}
+	up, err := us.Policy()
+	if err != nil {
+		return 0, err
+	}
+	if up != nil {
+		ps = append(ps, up)
+	}
+	if len(ps) == 0 {
+		return -1, nil
+	}
+	used := pending
+	if len(Filter(ps, func(i int, p *Policy) bool { return p.MaxTotal > 0 })) > 0 {
+		used += us.usedBytes()
+	}
+	limit := int64(-1)
+	for _, p := range ps {
+		n, err := p.check(name, fType, size, used)
+		if err != nil {
+			return 0, err
+		}
+		if n >= 0 && (limit < 0 || n < limit) {
+			limit = n
+		}
+	}
+	return limit, nil
+}
+
+// bytes of files of FileItems in user space
+func (us *UserSpace) usedBytes() (n int64) {
+	for _, fi := range us.FIs {
+		if info, err := os.Stat(fi.Path); err == nil {
+			n += info.Size()
+		}
+	}
+	return n
+}
+
+// remove empty directories from 'dir' up to, not including, 'stop'
+func pruneDirs(dir, stop string) {
+	for dir = filepath.Clean(dir); strings.HasPrefix(dir, filepath.Clean(stop)+PS); dir = filepath.Dir(dir) {
+		if empty, err := fd.IsDirEmpty(dir); err != nil || !empty || os.Remove(dir) != nil {
+			return
+		}
+	}
+}
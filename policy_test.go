@@ -0,0 +1,79 @@
+package filemgr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"testing"
+
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+// rejected by 'reason' of PolicyError
+func rejected(err error, reason string) bool {
+	pe := &PolicyError{}
+	return errors.As(err, &pe) && pe.Reason == reason
+}
+
+func TestPolicy(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("policy test")
+	lk.FailOnErr("%v", err)
+	defer us.SetPolicy(nil)
+
+	lk.FailOnErrWhen(us.SetPolicy(&Policy{MaxSize: -1}) == nil, "%v", fmt.Errorf("negative size MUST be invalid"))
+	lk.FailOnErr("%v", us.SetPolicy(&Policy{Exts: []string{"PNG", ".txt"}, MaxSizes: map[string]int64{"image": 200}, Verify: true}))
+	p, err := us.Policy()
+	lk.FailOnErr("%v", err)
+	fmt.Println(p)
+	lk.FailOnErrWhen(p == nil || p.MaxSizes["image"] != 200 || !p.Verify, "%v", fmt.Errorf("stored policy %v", p))
+
+	_, err = us.SaveFile(testFlat(4, 4, color.RGBA{255, 0, 0, 255}), "small.png", "", nil, false, "policy")
+	lk.FailOnErr("%v", err)
+	small := us.FIs[len(us.FIs)-1]
+	_, err = us.SaveFile(bytes.NewBufferString("plain note"), "note.txt", "", nil, false, "policy")
+	lk.FailOnErr("%v", err)
+
+	// rejected uploads leave nothing on disk
+	for _, c := range []struct {
+		name, reason string
+		content      *bytes.Buffer
+	}{
+		{"big.png", RejectSize, testFlat(400, 400, color.RGBA{0, 255, 0, 255})},
+		{"doc.pdf", RejectExt, bytes.NewBufferString("%PDF-1.4")},
+		{"fake.png", RejectMismatch, bytes.NewBufferString("<?php echo 1; ?>")},
+		{"pic.txt", RejectMismatch, testFlat(4, 4, color.RGBA{0, 0, 255, 255})},
+	} {
+		n := len(us.FIs)
+		_, err = us.SaveFile(c.content, c.name, "", nil, false, "policy-"+c.reason)
+		fmt.Println(err)
+		lk.FailOnErrWhen(!rejected(err, c.reason), "%v", fmt.Errorf("[%s] MUST be rejected for %s, got %v", c.name, c.reason, err))
+		lk.FailOnErrWhen(len(us.FIs) != n || fd.DirExists(filepath.Join(us.UserPath, "policy-"+c.reason)), "%v",
+			fmt.Errorf("[%s] MUST leave nothing", c.name))
+	}
+
+	// failure after policy check leaves nothing either
+	n := len(us.FIs)
+	_, err = us.SaveFile(testFlat(4, 4, color.RGBA{255, 0, 0, 255}), "crop.png", "", &ProcessOptions{Crop: &Rect{0, 0, 8, 8}}, false, "policy-process")
+	lk.FailOnErrWhen(err == nil || len(us.FIs) != n || fd.DirExists(filepath.Join(us.UserPath, "policy-process")), "%v",
+		fmt.Errorf("failed processing MUST leave nothing, got %v", err))
+
+	// manager policy applies with user policy
+	OptPolicy(&Policy{Types: []string{"image"}})
+	_, err = us.SaveFile(bytes.NewBufferString("plain note"), "note.txt", "", nil, false, "policy")
+	OptPolicy(nil)
+	lk.FailOnErrWhen(!rejected(err, RejectType), "%v", fmt.Errorf("text MUST be rejected by manager policy, got %v", err))
+
+	// copy is checked against policy of target
+	dst, err := UseUser("policy test dst")
+	lk.FailOnErr("%v", err)
+	defer dst.SetPolicy(nil)
+	lk.FailOnErr("%v", dst.SetPolicy(&Policy{Exts: []string{".txt"}}))
+	_, err = us.CopyTo(small.Id, dst, "policy")
+	lk.FailOnErrWhen(!rejected(err, RejectExt), "%v", fmt.Errorf("png MUST NOT be copied to txt only user, got %v", err))
+}
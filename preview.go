@@ -0,0 +1,271 @@
+package filemgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	CharsetUTF8    = "utf-8"
+	CharsetUTF16LE = "utf-16le"
+	CharsetUTF16BE = "utf-16be"
+	CharsetLatin1  = "latin-1"
+
+	maxPreview = 1 << 20 // bytes read at most for one preview
+	sniffLen   = 8 << 10 // bytes to detect charset & binary
+)
+
+// TextPreview is a safe view of text content transcoded to UTF-8, line endings normalized to "\n"
+type TextPreview struct {
+	Charset    string `json:"charset"`     // CharsetUTF8, CharsetUTF16LE, CharsetUTF16BE, CharsetLatin1, empty if binary
+	BOM        bool   `json:"bom"`         // content starts with byte order mark
+	LineEnding string `json:"line_ending"` // "lf", "crlf", "cr", "mixed", empty if single line
+	Binary     bool   `json:"binary"`      // content is NOT text, 'Text' is empty
+	Text       string `json:"text"`        // UTF-8
+	Lines      int    `json:"lines"`       // lines in 'Text'
+	Offset     int64  `json:"offset"`      // byte offset of previewed part in file
+	Length     int64  `json:"length"`      // bytes of previewed part in file
+	Size       int64  `json:"size"`        // bytes of file
+	Truncated  bool   `json:"truncated"`   // file has more content than previewed
+}
+
+// charset & BOM length of text sample, empty charset if binary
+func detectCharset(sample []byte) (charset string, bom int) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return CharsetUTF8, 3
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return CharsetUTF16LE, 2
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return CharsetUTF16BE, 2
+	}
+
+	// UTF-16 without BOM, mostly ASCII has zero in every other byte
+	if n := len(sample) / 2 * 2; n >= 4 {
+		even, odd := 0, 0
+		for i := 0; i < n; i += 2 {
+			if sample[i] == 0 {
+				even++
+			}
+			if sample[i+1] == 0 {
+				odd++
+			}
+		}
+		switch half := n / 2; {
+		case odd > half*3/4 && even == 0:
+			return CharsetUTF16LE, 0
+		case even > half*3/4 && odd == 0:
+			return CharsetUTF16BE, 0
+		}
+	}
+
+	ctrl := 0
+	for _, b := range sample {
+		switch {
+		case b == 0:
+			return "", 0
+		case b < 0x20 && !strings.ContainsRune("\t\n\r\f\b\x1b", rune(b)):
+			ctrl++
+		}
+	}
+	if ctrl > len(sample)/10 {
+		return "", 0
+	}
+	if utf8.Valid(trimPartialUTF8(sample)) {
+		return CharsetUTF8, 0
+	}
+	return CharsetLatin1, 0
+}
+
+// drop incomplete rune at end, such as cut by a byte range
+func trimPartialUTF8(data []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		if r := data[len(data)-i]; utf8.RuneStart(r) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// decode 'data' of 'charset' to UTF-8, trailing partial character is dropped
+func decodeText(data []byte, charset string) string {
+	switch charset {
+	case CharsetUTF16LE, CharsetUTF16BE:
+		var bo binary.ByteOrder = binary.LittleEndian
+		if charset == CharsetUTF16BE {
+			bo = binary.BigEndian
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			units = append(units, bo.Uint16(data[i:]))
+		}
+		if n := len(units); n > 0 && utf16.IsSurrogate(rune(units[n-1])) && units[n-1] < 0xDC00 {
+			units = units[:n-1] // high surrogate without its pair
+		}
+		return string(utf16.Decode(units))
+	case CharsetLatin1:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	default:
+		return strings.ToValidUTF8(string(trimPartialUTF8(data)), "�")
+	}
+}
+
+// kind of line endings, then 'text' with them normalized to "\n"
+func normalizeLines(text string) (ending, normalized string) {
+	crlf := strings.Count(text, "\r\n")
+	cr := strings.Count(text, "\r") - crlf
+	lf := strings.Count(text, "\n") - crlf
+	kinds := 0
+	for _, n := range []int{crlf, cr, lf} {
+		if n > 0 {
+			kinds++
+		}
+	}
+	switch {
+	case kinds > 1:
+		ending = "mixed"
+	case crlf > 0:
+		ending = "crlf"
+	case cr > 0:
+		ending = "cr"
+	case lf > 0:
+		ending = "lf"
+	}
+	return ending, strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+}
+
+func (us *UserSpace) openText(id string) (*os.File, int64, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(fis) == 0 {
+		return nil, 0, fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	f, err := os.Open(fis[0].Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// byte index after 'lines' line endings of 'data' in 'charset', -1 if fewer lines
+func lineEnd(data []byte, charset string, lines int) int {
+	unit, at := 1, func(i int) uint16 { return uint16(data[i]) }
+	switch charset {
+	case CharsetUTF16LE:
+		unit, at = 2, func(i int) uint16 { return binary.LittleEndian.Uint16(data[i:]) }
+	case CharsetUTF16BE:
+		unit, at = 2, func(i int) uint16 { return binary.BigEndian.Uint16(data[i:]) }
+	}
+	for i := 0; i+unit <= len(data); i += unit {
+		if c := at(i); c == '\n' || c == '\r' {
+			if c == '\r' && i+2*unit <= len(data) && at(i+unit) == '\n' {
+				i += unit
+			}
+			if lines--; lines == 0 {
+				return i + unit
+			}
+		}
+	}
+	return -1
+}
+
+// preview 'length' bytes from 'offset' of 'f', first 'maxLines' lines if positive.
+// charset is detected from head of file.
+func previewRange(f *os.File, size, offset, length int64, maxLines int) (*TextPreview, error) {
+	head := make([]byte, sniffLen)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	charset, bom := detectCharset(head[:n])
+	pv := &TextPreview{Charset: charset, BOM: bom > 0, Binary: charset == "", Size: size}
+	if pv.Binary {
+		return pv, nil
+	}
+
+	offset = max(offset, int64(bom))
+	if charset != CharsetUTF8 && charset != CharsetLatin1 && (offset-int64(bom))%2 != 0 {
+		offset-- // UTF-16 code unit boundary
+	}
+	length = max(0, min(length, maxPreview, size-offset))
+	data := make([]byte, length)
+	n, err = f.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	data = data[:n]
+	if charset == CharsetUTF8 {
+		skip := 0 // continuation bytes of character cut by 'offset'
+		for skip < len(data) && skip < utf8.UTFMax-1 && !utf8.RuneStart(data[skip]) {
+			skip++
+		}
+		data, offset = data[skip:], offset+int64(skip)
+		data = trimPartialUTF8(data)
+	}
+	if charset == CharsetUTF16LE || charset == CharsetUTF16BE {
+		data = data[:len(data)/2*2]
+	}
+	if maxLines > 0 {
+		if end := lineEnd(data, charset, maxLines); end >= 0 {
+			data = data[:end]
+		}
+	}
+
+	text := decodeText(data, charset)
+	pv.LineEnding, pv.Text = normalizeLines(text)
+	pv.Offset, pv.Length = offset, int64(len(data))
+	pv.Lines = strings.Count(pv.Text, "\n")
+	if pv.Text != "" && !strings.HasSuffix(pv.Text, "\n") {
+		pv.Lines++
+	}
+	pv.Truncated = offset+int64(len(data)) < size
+	return pv, nil
+}
+
+// PreviewText returns first 'maxLines' lines of first FileItem matching 'id' as UTF-8,
+// with detected charset, line endings & binary flag. At most 1MB is read.
+func (us *UserSpace) PreviewText(id string, maxLines int) (*TextPreview, error) {
+	if maxLines <= 0 {
+		return nil, fmt.Errorf("lines [%d] MUST be positive", maxLines)
+	}
+	f, size, err := us.openText(id)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return previewRange(f, size, 0, maxPreview, maxLines)
+}
+
+// PreviewTextRange returns 'length' bytes from 'offset' of first FileItem matching 'id' as UTF-8,
+// range is adjusted to character boundaries. At most 1MB is read.
+func (us *UserSpace) PreviewTextRange(id string, offset, length int64) (*TextPreview, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("range [%d, %d) is invalid", offset, offset+length)
+	}
+	f, size, err := us.openText(id)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return previewRange(f, size, offset, length, 0)
+}
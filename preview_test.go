@@ -0,0 +1,64 @@
+package filemgr
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	lk "github.com/digisan/logkit"
+)
+
+func utf16LE(s string) []byte {
+	b := []byte{0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return b
+}
+
+func TestPreviewText(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("preview test")
+	lk.FailOnErr("%v", err)
+
+	cases := []struct {
+		name      string
+		content   []byte
+		charset   string
+		ending    string
+		text      string
+		truncated bool
+	}{
+		{"key.txt", []byte("héllo\r\nwörld\r\nthird\r\n"), CharsetUTF8, "crlf", "héllo\nwörld\n", true},
+		{"wide.txt", utf16LE("añb\nc€d\n"), CharsetUTF16LE, "lf", "añb\nc€d\n", false},
+		{"latin.txt", []byte("caf\xe9\rna\xefve"), CharsetLatin1, "cr", "café\nnaïve", false},
+		{"blob.txt", []byte("ab\x00\x01\x02cd"), "", "", "", false},
+	}
+	for _, c := range cases {
+		_, err := us.SaveFile(bytes.NewReader(c.content), c.name, "", nil, false, "text")
+		lk.FailOnErr("%v", err)
+		fi := us.FIs[len(us.FIs)-1]
+		pv, err := us.PreviewText(fi.Id, 2)
+		lk.FailOnErr("%v", err)
+		fmt.Printf("%s: %+v\n", c.name, *pv)
+		lk.FailOnErrWhen(pv.Charset != c.charset || pv.LineEnding != c.ending || pv.Text != c.text || pv.Truncated != c.truncated, "%v",
+			fmt.Errorf("%s preview %+v", c.name, *pv))
+		lk.FailOnErrWhen(pv.Binary != (c.charset == ""), "%v", fmt.Errorf("%s binary MUST be %v", c.name, c.charset == ""))
+	}
+
+	// range cutting multi-byte characters
+	_, err = us.SaveFile(strings.NewReader("日本語のテキスト"), "ja.txt", "", nil, false, "text")
+	lk.FailOnErr("%v", err)
+	fi := us.FIs[len(us.FIs)-1]
+	pv, err := us.PreviewTextRange(fi.Id, 4, 7)
+	lk.FailOnErr("%v", err)
+	fmt.Printf("range: %+v\n", *pv)
+	lk.FailOnErrWhen(pv.Text != "語" || pv.Offset != 6 || pv.Length != 3 || !pv.Truncated, "%v", fmt.Errorf("range preview %+v", *pv))
+
+	_, err = us.PreviewTextRange(fi.Id, -1, 8)
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("negative offset MUST fail"))
+}
@@ -3,46 +3,277 @@ package filemgr
 import (
 	"errors"
 	"fmt"
+	"image"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
 	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
 	"github.com/jtguibas/cinema"
 )
 
-// note must be 'crop:x,y,w,h'
-func imageCrop(fPath, note, outFmt string) (fCrop string, err error) {
-	x, y, w, h := 0, 0, 0, 0
-	if n, err := fmt.Sscanf(note, "crop:%d,%d,%d,%d", &x, &y, &w, &h); err == nil && n == 4 {
-		img, err := loadImage(fPath)
-		if err != nil {
-			return "", err
-		}
+// Rect is a region of image or video frame, (0,0) is top-left
+type Rect struct {
+	X, Y, W, H int
+}
+
+// ProcessOptions are transforms applied to image or video right after saving, in order of
+// crop, resize, rotate & watermark, then encoded as 'Format'. Nil or zero value keeps file as it is.
+// Other file types are saved without processing.
+type ProcessOptions struct {
+	Crop      *Rect      // region of original, MUST be inside actual dimensions
+	Width     int        // resized width before rotating, 0 keeps aspect ratio from 'Height'
+	Height    int        // resized height before rotating, 0 keeps aspect ratio from 'Width'
+	Rotate    int        // clockwise degrees, multiple of 90
+	Format    string     // image: "png", "jpg"; video: "mp4", "webm". empty keeps source format, "png" or "mp4" if unsupported, animated gif keeps gif
+	Quality   int        // 1-100, jpg quality or percentage of original video bitrate. 0: jpg 90, video original
+	Watermark *Watermark // drawn permanently over image or every video frame, nil for none
+}
+
+// ErrInvalid is wrapped by errors of options invalid for the file, they fail the same way on every try
+var ErrInvalid = errors.New("invalid options")
+
+var (
+	imageFormats = []string{"png", "jpg"}
+	videoFormats = []string{"mp4", "webm"}
+)
+
+func (po *ProcessOptions) isZero() bool {
+	return po == nil || *po == ProcessOptions{}
+}
 
-		roi := roi4rgba(img, x, y, x+w, y+h)
-		fCrop = fd.ChangeFileName(fPath, "", "-crop")
-		fCrop = strings.TrimSuffix(fCrop, filepath.Ext(fCrop))
+// copy of 'po' with watermark image resolved in 'us'
+func (po *ProcessOptions) resolve(us *UserSpace) (*ProcessOptions, error) {
+	if po.isZero() || po.Watermark == nil {
+		return po, nil
+	}
+	wm, err := po.Watermark.resolve(us)
+	if err != nil {
+		return nil, err
+	}
+	v := *po
+	v.Watermark = wm
+	return &v, nil
+}
 
-		switch outFmt {
-		case ".png", "png":
-			fCrop += ".png"
-			if _, err := savePNG(roi, fCrop); err != nil {
-				return "", err
+// check options against actual 'width' & 'height', return normalized copy. 'src' is format of source,
+// such as extension, kept if 'Format' is empty
+func (po *ProcessOptions) validate(fType, src string, width, height int) (vpo *ProcessOptions, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}()
+	v := *po
+	if c := v.Crop; c != nil {
+		if c.X < 0 || c.Y < 0 || c.W <= 0 || c.H <= 0 || c.X+c.W > width || c.Y+c.H > height {
+			return nil, fmt.Errorf("crop %v is out of %dx%d", *c, width, height)
+		}
+		width, height = c.W, c.H
+	}
+	if v.Width < 0 || v.Height < 0 {
+		return nil, fmt.Errorf("resize %dx%d is invalid", v.Width, v.Height)
+	}
+	switch {
+	case v.Width == 0 && v.Height == 0:
+		v.Width, v.Height = width, height
+	case v.Width == 0:
+		v.Width = max(1, width*v.Height/height)
+	case v.Height == 0:
+		v.Height = max(1, height*v.Width/width)
+	}
+	if v.Rotate%90 != 0 {
+		return nil, fmt.Errorf("rotate [%d] is not multiple of 90", v.Rotate)
+	}
+	v.Rotate = (v.Rotate%360 + 360) % 360
+	if v.Quality < 0 || v.Quality > 100 {
+		return nil, fmt.Errorf("quality [%d] is out of 1-100", v.Quality)
+	}
+	if v.Watermark != nil {
+		if err := v.Watermark.validate(); err != nil {
+			return nil, err
+		}
+	}
+	v.Format, src = normFormat(v.Format), normFormat(src)
+	switch fType {
+	case fd.Image:
+		if v.Format == "" {
+			v.Format = "png"
+			if In(src, imageFormats...) {
+				v.Format = src
 			}
-		case ".jpg", "jpg":
-			fCrop += ".jpg"
-			if _, err := saveJPG(roi, fCrop); err != nil {
-				return "", err
+		}
+		if NotIn(v.Format, imageFormats...) {
+			return nil, fmt.Errorf("image format [%s] is unsupported, only %v", v.Format, imageFormats)
+		}
+	case fd.Video:
+		if v.Format == "" {
+			v.Format = "mp4"
+			if In(src, videoFormats...) {
+				v.Format = src
 			}
-		default:
-			fCrop += ".png"
-			if _, err := savePNG(roi, fCrop); err != nil {
-				return "", err
+		}
+		if NotIn(v.Format, videoFormats...) {
+			return nil, fmt.Errorf("video format [%s] is unsupported, only %v", v.Format, videoFormats)
+		}
+	}
+	return &v, nil
+}
+
+// "JPEG", ".jpeg" => "jpg"
+func normFormat(f string) string {
+	if f = strings.TrimPrefix(strings.ToLower(f), "."); f == "jpeg" {
+		return "jpg"
+	}
+	return f
+}
+
+// apply 'po' to image or video at 'fPath', return path of processed file, extension follows output format.
+// 'fPath' is overwritten if returned path is the same, otherwise it is kept for caller to remove.
+func process(fPath, fType string, po *ProcessOptions) (string, error) {
+	if po.isZero() || NotIn(fType, fd.Image, fd.Video) {
+		return fPath, nil
+	}
+	out := strings.TrimSuffix(fPath, filepath.Ext(fPath))
+	tmp := out + "-proc"
+	var err error
+	switch fType {
+	case fd.Image:
+		tmp, err = imageProcess(fPath, tmp, po)
+	case fd.Video:
+		tmp, err = videoProcess(fPath, tmp, po)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	out += filepath.Ext(tmp)
+	return out, os.Rename(tmp, out)
+}
+
+// 'fi' takes processed content at 'p' in DB keeping its id & refreshing metadata, then replaced file is removed.
+// Only path, metadata & converted display name are written, other fields may be edited meanwhile.
+func newVersion(fi *fdb.FileItem, p string) error {
+	oldPath, nv := fi.Path, *fi
+	nv.Path = p
+	refreshMeta(&nv)
+	cur, err := fdb.EditFileItem(fi.Id, func(cur *fdb.FileItem) error {
+		if cur.Path != oldPath {
+			return fmt.Errorf("[%s] is moved to [%s] while processing", oldPath, cur.Path)
+		}
+		if p != oldPath && (cur.DispName == "" || cur.DispName == convertedName(cur.OriName, oldPath)) {
+			cur.DispName = convertedName(cur.OriName, p)
+		}
+		cur.Path, cur.Meta = p, nv.Meta
+		return nil
+	})
+	if err != nil {
+		if p != oldPath {
+			os.Remove(p)
+		}
+		return err
+	}
+	*fi = *cur
+	if p != oldPath {
+		lk.WarnOnErr("%v", os.Remove(oldPath))
+	}
+	return nil
+}
+
+// animated GIF or APNG keeps its animation if 'Format' is empty, "gif" or "png", otherwise first frame is saved
+func imageProcess(fPath, out string, po *ProcessOptions) (string, error) {
+	img, err := loadImage(fPath)
+	if err != nil {
+		return "", err
+	}
+	anim, err := loadAnimation(fPath)
+	if err != nil {
+		return "", err
+	}
+	keep := "" // format of animation output, "gif" or "png"
+	if f := strings.ToLower(po.Format); anim != nil && In(f, "", "gif", "png") {
+		keep = anim.format
+		if f != "" {
+			keep = f
+		}
+		v := *po
+		v.Format = "png" // validated as still png, encoded as 'keep'
+		po = &v
+	}
+	b := img.Bounds()
+	if po, err = po.validate(fd.Image, filepath.Ext(fPath), b.Dx(), b.Dy()); err != nil {
+		return "", err
+	}
+
+	// every frame of animation is composited on full canvas, so one pipeline fits all
+	pipe := func(img image.Image) (image.Image, error) {
+		b := img.Bounds()
+		if c := po.Crop; c != nil {
+			img = roi4rgba(img, b.Min.X+c.X, b.Min.Y+c.Y, b.Min.X+c.X+c.W, b.Min.Y+c.Y+c.H)
+		}
+		if img.Bounds().Dx() != po.Width || img.Bounds().Dy() != po.Height {
+			img = resizeImage(img, po.Width, po.Height)
+		}
+		img = rotateImage(img, po.Rotate)
+		if po.Watermark != nil {
+			return po.Watermark.apply(img)
+		}
+		return img, nil
+	}
+
+	if keep != "" {
+		if err := anim.transform(pipe); err != nil {
+			return "", err
+		}
+		out += "." + keep
+		return out, anim.save(out, keep)
+	}
+	out += "." + po.Format
+	if img, err = pipe(img); err != nil {
+		return "", err
+	}
+	switch po.Format {
+	case "jpg":
+		quality := po.Quality
+		if quality == 0 {
+			quality = 90
+		}
+		_, err = saveJPG(img, out, quality)
+	default:
+		_, err = savePNG(img, out)
+	}
+	return out, err
+}
+
+// clockwise, 'deg' is one of 0, 90, 180, 270
+func rotateImage(img image.Image, deg int) image.Image {
+	if deg == 0 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if deg != 180 {
+		w, h = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			switch deg {
+			case 90:
+				dst.Set(w-1-y, x, c)
+			case 180:
+				dst.Set(w-1-x, h-1-y, c)
+			case 270:
+				dst.Set(y, h-1-x, c)
 			}
 		}
-		return fCrop, nil
 	}
-	return "", errors.New("note must be 'crop:x,y,w,h' for cropping")
+	return dst
 }
 
 /////////////////////////////////////////////////////////////////////////////////
@@ -50,21 +281,44 @@ func imageCrop(fPath, note, outFmt string) (fCrop string, err error) {
 // sudo apt install ffmpeg
 // https://pkg.go.dev/github.com/jtguibas/cinema#section-readme
 
-// note must be 'crop:x,y,w,h'
-func videoCrop(fPath, note string) (fCrop string, err error) {
-	x, y, w, h := 0, 0, 0, 0
-	if n, err := fmt.Sscanf(note, "crop:%d,%d,%d,%d", &x, &y, &w, &h); err == nil && n == 4 {
-		fCrop = fd.ChangeFileName(fPath, "", "-crop")
-		fCrop = strings.TrimSuffix(fCrop, filepath.Ext(fCrop)) + ".mp4"
-		video, err := cinema.Load(fPath)
-		if err != nil {
-			return "", err
+func videoProcess(fPath, out string, po *ProcessOptions) (string, error) {
+	video, err := cinema.Load(fPath)
+	if err != nil {
+		return "", err
+	}
+	if po, err = po.validate(fd.Video, filepath.Ext(fPath), video.Width(), video.Height()); err != nil {
+		return "", err
+	}
+	if c := po.Crop; c != nil {
+		video.Crop(c.X, c.Y, c.W, c.H)
+	}
+	if video.Width() != po.Width || video.Height() != po.Height {
+		video.SetSize(po.Width/2*2, po.Height/2*2) // even dimensions for most codecs
+	}
+	if po.Quality > 0 {
+		video.SetBitrate(max(1, video.Bitrate()*po.Quality/100))
+	}
+
+	out += "." + po.Format
+	line := video.CommandLine(out)
+	if po.Rotate != 0 {
+		transpose := map[int]string{90: "transpose=1,", 180: "transpose=1,transpose=1,", 270: "transpose=2,"}[po.Rotate]
+		for i, arg := range line {
+			if arg == "-vf" && i+1 < len(line) {
+				line[i+1] = strings.Replace(line[i+1], "setsar=", transpose+"setsar=", 1)
+			}
 		}
-		video.Crop(x, y, w, h)
-		if err := video.Render(fCrop); err != nil {
-			return "", err
+	}
+	if output, err := exec.Command(line[0], line[1:]...).CombinedOutput(); err != nil {
+		return out, fmt.Errorf("ffmpeg failed: %v, %s", err, output)
+	}
+	if po.Watermark != nil {
+		marked := strings.TrimSuffix(out, filepath.Ext(out)) + "-mark" + filepath.Ext(out)
+		if err := watermarkVideo(out, marked, po.Watermark, nil); err != nil {
+			os.Remove(marked)
+			return out, err
 		}
-		return fCrop, nil
+		return out, os.Rename(marked, out)
 	}
-	return "", errors.New("note must be 'crop:x,y,w,h' for cropping")
+	return out, nil
 }
@@ -1,15 +1,89 @@
 package filemgr
 
 import (
+	"bytes"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
 )
 
-func TestVideoCrop(t *testing.T) {
-	fmt.Println(videoCrop("./samples/Screencast", "crop:100,200,500,400"))
-	fmt.Println(videoCrop("./samples/Screencast1.mp4", "crop:100,200,500,400"))
+func TestVideoProcess(t *testing.T) {
+	fmt.Println(videoProcess("./samples/Screencast", "./samples/Screencast-proc", &ProcessOptions{Crop: &Rect{100, 200, 500, 400}}))
+	fmt.Println(videoProcess("./samples/Screencast1.mp4", "./samples/Screencast1-proc", &ProcessOptions{Width: 320, Rotate: 90}))
+}
+
+func testPNG(w, h int) *bytes.Buffer {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	lk.FailOnErr("%v", png.Encode(buf, img))
+	return buf
 }
 
-func TestImageCrop(t *testing.T) {
-	fmt.Println(imageCrop("./samples/moon", "crop:100,200,500,400", "jpg"))
+func TestImageProcess(t *testing.T) {
+
+	InitFileMgr("./data")
+	OptThumbOnSave(false)
+	defer OptThumbOnSave(true)
+
+	us, err := UseUser("process test")
+	lk.FailOnErr("%v", err)
+
+	// crop 200x100, resize to width 100, rotate 90 => 50x100 jpg
+	po := &ProcessOptions{Crop: &Rect{X: 10, Y: 20, W: 200, H: 100}, Width: 100, Rotate: 90, Format: "jpeg", Quality: 80}
+	path, err := us.SaveFile(testPNG(400, 300), "photo.png", "my real note", po, false, "album")
+	lk.FailOnErr("%v", err)
+	fmt.Println(path)
+	lk.FailOnErrWhen(filepath.Ext(path) != ".jpg", "%v", fmt.Errorf("format: %s", path))
+
+	img, err := loadImage(path)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(img.Bounds().Dx() != 50 || img.Bounds().Dy() != 100, "%v", fmt.Errorf("bounds: %v", img.Bounds()))
+
+	fi := us.FIs[len(us.FIs)-1]
+	fmt.Println(fi.Note, fi.Name(), fi.OriginalName())
+	lk.FailOnErrWhen(fi.Note != "my real note", "%v", fmt.Errorf("note: %s", fi.Note))
+	lk.FailOnErrWhen(fi.Name() != "photo.jpg" || fi.OriginalName() != "photo.png", "%v", fmt.Errorf("name: %s", fi.Name()))
+
+	// crop out of actual dimensions is rejected, nothing is left on disk
+	n := len(us.FIs)
+	_, err = us.SaveFile(testPNG(400, 300), "bad.png", "", &ProcessOptions{Crop: &Rect{300, 200, 200, 200}}, false, "album")
+	fmt.Println(err)
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("out of bounds crop accepted"))
+	lk.FailOnErrWhen(len(us.FIs) != n, "%v", fmt.Errorf("failed save added file item"))
+	files, _, err := fd.WalkFileDir(us.UserPath, true)
+	lk.FailOnErr("%v", err)
+	for _, f := range files {
+		lk.FailOnErrWhen(strings.Contains(f, "bad-"), "%v", fmt.Errorf("left on disk: %s", f))
+	}
+
+	for _, p := range []*ProcessOptions{{Rotate: 45}, {Format: "gif"}, {Quality: 101}, {Width: -1}} {
+		_, err := us.SaveFile(testPNG(40, 30), "bad.png", "", p, false, "album")
+		lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("invalid options accepted: %+v", *p))
+	}
+
+	// empty format keeps source format
+	_, err = us.SaveFile(testJPEG(40, 20, 1), "keep.jpg", "", &ProcessOptions{Width: 20}, false, "album")
+	lk.FailOnErr("%v", err)
+	kept := us.FIs[len(us.FIs)-1]
+	lk.FailOnErrWhen(filepath.Ext(kept.Path) != ".jpg" || kept.Name() != "keep.jpg", "%v", fmt.Errorf("format changed: %s", kept.Path))
+
+	// options are ignored for other types
+	_, err = us.SaveFile(strings.NewReader("plain"), "a.txt", "", po, false, "album")
+	lk.FailOnErr("%v", err)
+
+	for _, fi := range us.FIs {
+		lk.FailOnErr("%v", us.DelFileItem(fi.ID()))
+	}
 }
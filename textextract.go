@@ -0,0 +1,352 @@
+package filemgr
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+	fd "github.com/digisan/gotk/file-dir"
+)
+
+const (
+	maxText    = 4 << 20  // bytes of extracted text kept for search
+	maxXML     = 64 << 20 // inflated bytes of document XML parsed for text
+	maxPDF     = 64 << 20 // bytes of PDF scanned for text & pages
+	snippetLen = 200      // runes of preview snippet
+	textFile   = "text.txt"
+)
+
+// TextExtractor pulls plain text & page count, 0 if unknown, from document file 'fPath', offline
+type TextExtractor func(fPath string) (text string, pages int, err error)
+
+var extractors = struct {
+	sync.RWMutex
+	m map[string]TextExtractor
+}{m: map[string]TextExtractor{
+	".pdf":  pdfText,
+	".docx": docxText,
+	".odt":  odtText,
+	".txt":  plainText,
+	".md":   plainText,
+	".csv":  plainText,
+	".json": plainText,
+	".xml":  plainText,
+	".html": plainText,
+}}
+
+// RegisterTextExtractor sets extractor of file extension 'ext' such as ".rtf", nil removes it
+func RegisterTextExtractor(ext string, fn TextExtractor) {
+	extractors.Lock()
+	defer extractors.Unlock()
+
+	ext = strings.ToLower("." + strings.TrimPrefix(ext, "."))
+	if fn == nil {
+		delete(extractors.m, ext)
+		return
+	}
+	extractors.m[ext] = fn
+}
+
+func textExtractor(fPath, mimeType string) TextExtractor {
+	extractors.RLock()
+	defer extractors.RUnlock()
+
+	if fn, ok := extractors.m[strings.ToLower(filepath.Ext(fPath))]; ok {
+		return fn
+	}
+	if strings.HasPrefix(mimeType, "text/") {
+		return plainText
+	}
+	return nil
+}
+
+// "root/name/.fi/id/text.txt"
+func (us *UserSpace) textPath(fi *fdb.FileItem) string {
+	return filepath.Join(us.artifactDir(fi), textFile)
+}
+
+// first runes of 'text' with white spaces collapsed
+func snippet(text string) string {
+	sb := strings.Builder{}
+	n, space := 0, false
+	for _, r := range text {
+		if n >= snippetLen {
+			break
+		}
+		if unicode.IsSpace(r) {
+			space = sb.Len() > 0
+			continue
+		}
+		if space {
+			sb.WriteRune(' ')
+			n, space = n+1, false
+		}
+		sb.WriteRune(r)
+		n++
+	}
+	return sb.String()
+}
+
+// extract text of document 'fi' into its artifact directory, then fill page count & snippet of 'meta'.
+// Other types are ignored.
+func (us *UserSpace) extractText(fi *fdb.FileItem, meta *fdb.Meta) error {
+	if In(fi.Type(), fd.Image, fd.Video, fd.Audio) {
+		return nil
+	}
+	fn := textExtractor(fi.Path, meta.MIME)
+	if fn == nil {
+		return nil
+	}
+	text, pages, err := fn(fi.Path)
+	if err != nil {
+		return err
+	}
+	if len(text) > maxText {
+		text = text[:maxText]
+	}
+	text = strings.ToValidUTF8(text, "")
+	fd.MustCreateDir(us.artifactDir(fi))
+	if err := os.WriteFile(us.textPath(fi), []byte(text), 0o644); err != nil {
+		return err
+	}
+	if pages > 0 {
+		meta.Pages = pages
+	}
+	meta.Snippet = snippet(text)
+	return nil
+}
+
+// Text returns extracted plain text of first document FileItem matching 'id', for search & preview
+func (us *UserSpace) Text(id string) (string, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return "", err
+	}
+	if len(fis) == 0 {
+		return "", fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	data, err := os.ReadFile(us.textPath(fis[0]))
+	if err != nil {
+		return "", fmt.Errorf("text of [%s]: %w", id, err)
+	}
+	return string(data), nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+func plainText(fPath string) (string, int, error) {
+	data, err := readHead(fPath, maxText)
+	if err != nil {
+		return "", 0, err
+	}
+	if !utf8.Valid(data) && bytes.IndexByte(data, 0) >= 0 {
+		return "", 0, fmt.Errorf("[%s] is not plain text", filepath.Base(fPath))
+	}
+	return string(data), 0, nil
+}
+
+// text of XML 'r', 'breaks' elements end lines, 'spaces' elements are blanks, 'texts' elements hold text
+func xmlText(r io.Reader, texts, breaks, spaces []string) (string, error) {
+	sb := strings.Builder{}
+	dec := xml.NewDecoder(r)
+	depth := 0 // inside text elements
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return sb.String(), nil
+		}
+		if err != nil {
+			return sb.String(), err
+		}
+		if sb.Len() >= maxText {
+			return sb.String(), nil
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			switch {
+			case In(name, texts...):
+				depth++
+			case In(name, spaces...):
+				sb.WriteByte(' ')
+			}
+		case xml.EndElement:
+			name := t.Name.Local
+			if In(name, texts...) && depth > 0 {
+				depth--
+			}
+			if In(name, breaks...) {
+				sb.WriteByte('\n')
+			}
+		case xml.CharData:
+			if depth > 0 {
+				sb.Write(t)
+			}
+		}
+	}
+}
+
+// open entry 'name' of zip
+func zipEntry(zr *zip.ReadCloser, name string) (io.ReadCloser, error) {
+	for _, f := range zr.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+	return nil, fmt.Errorf("[%s] %w", name, os.ErrNotExist)
+}
+
+var (
+	rDocxPages = regexp.MustCompile(`<Pages>(\d+)</Pages>`)
+	rOdtPages  = regexp.MustCompile(`meta:page-count="(\d+)"`)
+)
+
+// text of 'doc' & page count matched by 'rPages' in 'props' of zip based document
+func zipDocText(fPath, doc, props string, rPages *regexp.Regexp, texts, breaks, spaces []string) (string, int, error) {
+	zr, err := zip.OpenReader(fPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer zr.Close()
+
+	rc, err := zipEntry(zr, doc)
+	if err != nil {
+		return "", 0, err
+	}
+	lr := &io.LimitedReader{R: rc, N: maxXML}
+	text, err := xmlText(lr, texts, breaks, spaces)
+	rc.Close()
+	if err != nil && lr.N > 0 { // truncated at 'maxXML' keeps text before it
+		return "", 0, err
+	}
+
+	pages := 0
+	if rc, err := zipEntry(zr, props); err == nil {
+		data, _ := io.ReadAll(io.LimitReader(rc, 1<<20))
+		rc.Close()
+		if m := rPages.FindSubmatch(data); m != nil {
+			pages, _ = strconv.Atoi(string(m[1]))
+		}
+	}
+	return text, pages, nil
+}
+
+func docxText(fPath string) (string, int, error) {
+	return zipDocText(fPath, "word/document.xml", "docProps/app.xml", rDocxPages,
+		[]string{"t"}, []string{"p", "br", "cr"}, []string{"tab"})
+}
+
+func odtText(fPath string) (string, int, error) {
+	return zipDocText(fPath, "content.xml", "meta.xml", rOdtPages,
+		[]string{"p", "h"}, []string{"p", "h", "line-break"}, []string{"s", "tab"})
+}
+
+var (
+	rPdfStream = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	rPdfTextOp = regexp.MustCompile(`(?s)\[(.*?)\]\s*TJ|\((.*?[^\\])\)\s*(?:Tj|'|")|(T\*|ET|Td|TD)`)
+	rPdfTJItem = regexp.MustCompile(`(?s)\((.*?[^\\])\)|(-?\d+(?:\.\d+)?)`)
+)
+
+// decode PDF literal string escapes
+func pdfString(s string) string {
+	sb := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r', 'b', 'f':
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			v, _ := strconv.ParseUint(s[i:j], 8, 8)
+			sb.WriteByte(byte(v))
+			i = j - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// text shown by operators of PDF content stream
+func pdfContentText(content []byte) string {
+	sb := strings.Builder{}
+	for _, m := range rPdfTextOp.FindAllSubmatch(content, -1) {
+		switch {
+		case m[1] != nil: // [(a) -20 (b)] TJ, large negative kerning is a space
+			for _, item := range rPdfTJItem.FindAllSubmatch(m[1], -1) {
+				if item[1] != nil {
+					sb.WriteString(pdfString(string(item[1])))
+				} else if kern, _ := strconv.ParseFloat(string(item[2]), 64); kern < -200 {
+					sb.WriteByte(' ')
+				}
+			}
+		case m[2] != nil:
+			sb.WriteString(pdfString(string(m[2])))
+		case m[3] != nil: // new line or end of text block
+			if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+				sb.WriteByte('\n')
+			}
+		}
+	}
+	return sb.String()
+}
+
+// text of literal strings in PDF content streams, Flate compressed or not.
+// Fonts with custom encodings & object streams are NOT decoded.
+func pdfText(fPath string) (string, int, error) {
+	data, err := readHead(fPath, maxPDF)
+	if err != nil {
+		return "", 0, err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", 0, fmt.Errorf("[%s] is not PDF", filepath.Base(fPath))
+	}
+	sb := strings.Builder{}
+	for _, loc := range rPdfStream.FindAllSubmatchIndex(data, -1) {
+		dict, start := data[loc[2]:loc[3]], loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		content := data[start : start+end]
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			zr, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			content, _ = io.ReadAll(io.LimitReader(zr, maxText)) // keep what is inflated before corruption
+			zr.Close()
+		case bytes.Contains(dict, []byte("/Filter")):
+			continue // image or unsupported filter
+		}
+		sb.WriteString(pdfContentText(content))
+		if sb.Len() > maxText {
+			break
+		}
+	}
+	return sb.String(), pdfPageCount(data), nil
+}
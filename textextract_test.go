@@ -0,0 +1,128 @@
+package filemgr
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lk "github.com/digisan/logkit"
+)
+
+func testZip(files map[string]string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		lk.FailOnErr("%v", err)
+		_, err = w.Write([]byte(content))
+		lk.FailOnErr("%v", err)
+	}
+	lk.FailOnErr("%v", zw.Close())
+	return buf
+}
+
+func testPDF() *bytes.Buffer {
+	zbuf := &bytes.Buffer{}
+	zw := zlib.NewWriter(zbuf)
+	zw.Write([]byte("BT /F1 12 Tf 72 700 Td [(Second) -300 (page)] TJ ET"))
+	zw.Close()
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("%PDF-1.4\n1 0 obj << /Type /Pages /Kids [2 0 R 3 0 R] /Count 2 >> endobj\n")
+	buf.WriteString("2 0 obj << /Type /Page /Contents 4 0 R >> endobj\n3 0 obj << /Type /Page /Contents 5 0 R >> endobj\n")
+	buf.WriteString("4 0 obj << /Length 44 >>\nstream\nBT /F1 12 Tf 72 700 Td (Hello \\(PDF\\) world) Tj ET\nendstream endobj\n")
+	fmt.Fprintf(buf, "5 0 obj << /Length %d /Filter /FlateDecode >>\nstream\n", zbuf.Len())
+	buf.Write(zbuf.Bytes())
+	buf.WriteString("\nendstream endobj\n%%EOF\n")
+	return buf
+}
+
+func TestTextExtract(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("text test")
+	lk.FailOnErr("%v", err)
+
+	docx := testZip(map[string]string{
+		"word/document.xml": `<w:document xmlns:w="w"><w:body><w:p><w:r><w:t>Quarterly</w:t></w:r><w:r><w:tab/><w:t>report</w:t></w:r></w:p><w:p><w:r><w:t>Revenue grew.</w:t></w:r></w:p></w:body></w:document>`,
+		"docProps/app.xml":  `<Properties><Pages>3</Pages></Properties>`,
+	})
+	odt := testZip(map[string]string{
+		"content.xml": `<office:document-content xmlns:office="o" xmlns:text="t"><office:body><office:text><text:h>Minutes</text:h><text:p>Meeting<text:s/>notes</text:p></office:text></office:body></office:document-content>`,
+		"meta.xml":    `<office:document-meta><meta:document-statistic meta:page-count="1"/></office:document-meta>`,
+	})
+
+	cases := []struct {
+		name    string
+		content *bytes.Buffer
+		text    []string
+		snippet string
+		pages   int
+	}{
+		{"notes.txt", bytes.NewBufferString("  plain\n\n text   file "), []string{"plain"}, "plain text file", 0},
+		{"report.docx", docx, []string{"Quarterly report\n", "Revenue grew."}, "Quarterly report Revenue grew.", 3},
+		{"minutes.odt", odt, []string{"Minutes\n", "Meeting notes"}, "Minutes Meeting notes", 1},
+		{"paper.pdf", testPDF(), []string{"Hello (PDF) world", "Second page"}, "Hello (PDF) world Second page", 2},
+	}
+	for _, c := range cases {
+		_, err := us.SaveFile(c.content, c.name, "", nil, false, "docs")
+		lk.FailOnErr("%v", err)
+		fi := us.FIs[len(us.FIs)-1]
+		text, err := us.Text(fi.Id)
+		lk.FailOnErr("%v", err)
+		info, err := os.Stat(us.textPath(fi))
+		lk.FailOnErr("%v", err)
+		lk.FailOnErrWhen(info.Mode().Perm() != 0o644, "%v", fmt.Errorf("%s text mode %v", c.name, info.Mode()))
+		fmt.Printf("%s: %q %q %d\n", c.name, text, fi.Snippet(), fi.Pages())
+		for _, s := range c.text {
+			lk.FailOnErrWhen(!strings.Contains(text, s), "%v", fmt.Errorf("%s text MUST contain %q", c.name, s))
+		}
+		lk.FailOnErrWhen(fi.Snippet() != c.snippet || fi.Pages() != c.pages, "%v",
+			fmt.Errorf("%s snippet %q pages %d", c.name, fi.Snippet(), fi.Pages()))
+	}
+
+	// pluggable
+	RegisterTextExtractor("rtf", func(fPath string) (string, int, error) { return "rich text", 1, nil })
+	defer RegisterTextExtractor(".rtf", nil)
+	_, err = us.SaveFile(strings.NewReader(`{\rtf1 rich text}`), "memo.rtf", "", nil, false, "docs")
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(us.FIs[len(us.FIs)-1].Snippet() != "rich text", "%v", fmt.Errorf("registered extractor is NOT used"))
+}
+
+func TestTextExtractLimits(t *testing.T) {
+
+	huge := func(fill string, size int) string {
+		sb := strings.Builder{}
+		sb.WriteString(`<w:document xmlns:w="w"><w:body><w:p><w:r><w:t>head</w:t></w:r></w:p>`)
+		for sb.Len() < size {
+			sb.WriteString(fill)
+		}
+		sb.WriteString(`</w:body></w:document>`)
+		path := filepath.Join(t.TempDir(), "huge.docx")
+		lk.FailOnErr("%v", os.WriteFile(path, testZip(map[string]string{"word/document.xml": sb.String()}).Bytes(), 0o644))
+		return path
+	}
+
+	// text of huge document stops at 'maxText'
+	text, _, err := docxText(huge(`<w:p><w:r><w:t>lorem ipsum dolor sit amet</w:t></w:r></w:p>`, 4*maxText))
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(text) < maxText || len(text) > maxText+100, "%v", fmt.Errorf("text of %d bytes", len(text)))
+
+	// XML inflated beyond 'maxXML' is truncated, text before it is kept
+	text, _, err = docxText(huge(`<w:p/>`, maxXML+1024))
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(!strings.HasPrefix(text, "head"), "%v", fmt.Errorf("text %q", text))
+
+	// pages are counted in head of PDF only
+	pdf := append(testPDF().Bytes(), make([]byte, maxPDF)...)
+	pdf = append(pdf, "/Type /Page\n"...)
+	path := filepath.Join(t.TempDir(), "long.pdf")
+	lk.FailOnErr("%v", os.WriteFile(path, pdf, 0o644))
+	lk.FailOnErrWhen(pdfPages(path) != 2, "%v", fmt.Errorf("pages %d", pdfPages(path)))
+}
@@ -0,0 +1,80 @@
+package filemgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+	fd "github.com/digisan/gotk/file-dir"
+)
+
+const posterAt = 1 * time.Second // poster frame position of video thumbnail
+
+// "root/name/.fi/id/thumb-size.png"
+func (us *UserSpace) thumbPath(fi *fdb.FileItem, size int) string {
+	return filepath.Join(us.artifactDir(fi), fmt.Sprintf("thumb-%d.png", size))
+}
+
+// thumbnail is stale if missing or older than FileItem content
+func thumbFresh(fi *fdb.FileItem, path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	src, err := os.Stat(fi.Path)
+	return err == nil && !info.ModTime().Before(src.ModTime())
+}
+
+// render thumbnails of 'sizes' for image or video FileItem, other types are ignored
+func (us *UserSpace) renderThumbs(fi *fdb.FileItem, sizes ...int) error {
+	if len(sizes) == 0 {
+		sizes = opt.thumbSizes
+	}
+	if NotIn(fi.Type(), fd.Image, fd.Video) {
+		return nil
+	}
+	src, err := us.renderPoster(fi)
+	if err != nil {
+		return err
+	}
+	img, err := loadImage(src)
+	if err != nil {
+		return err
+	}
+	fd.MustCreateDir(us.artifactDir(fi))
+	for _, size := range sizes {
+		if _, err := savePNG(fitImage(img, size), us.thumbPath(fi, size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Thumbnail returns PNG path of thumbnail of first FileItem matching 'id', longest edge is 'size' pixels.
+// 'size' MUST be one of 'OptThumbSizes', missing or stale thumbnail is rendered on demand.
+func (us *UserSpace) Thumbnail(id string, size int) (string, error) {
+	if NotIn(size, opt.thumbSizes...) {
+		return "", fmt.Errorf("thumbnail size [%d] is unsupported, only %v", size, opt.thumbSizes)
+	}
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return "", err
+	}
+	if len(fis) == 0 {
+		return "", fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	fi := fis[0]
+	if NotIn(fi.Type(), fd.Image, fd.Video) {
+		return "", fmt.Errorf("[%s] is %s, no thumbnail", id, fi.Type())
+	}
+	path := us.thumbPath(fi, size)
+	if !thumbFresh(fi, path) {
+		if err := us.renderThumbs(fi, size); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,58 @@
+package filemgr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+func TestThumbnail(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("thumb test")
+	lk.FailOnErr("%v", err)
+
+	img := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 800; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	lk.FailOnErr("%v", png.Encode(buf, img))
+
+	_, err = us.SaveFile(buf, "wide.png", "", nil, true, "gallery")
+	lk.FailOnErr("%v", err)
+	fi := us.FIs[len(us.FIs)-1]
+
+	// rendered on save
+	for _, size := range []int{128, 512} {
+		path, err := us.Thumbnail(fi.ID(), size)
+		lk.FailOnErr("%v", err)
+		thumb, err := loadImage(path)
+		lk.FailOnErr("%v", err)
+		fmt.Println(path, thumb.Bounds())
+		lk.FailOnErrWhen(thumb.Bounds().Dx() != size || thumb.Bounds().Dy() != size/2, "%v", fmt.Errorf("thumbnail bounds: %v", thumb.Bounds()))
+	}
+
+	_, err = us.Thumbnail(fi.ID(), 100)
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("unsupported size accepted"))
+
+	// on demand after cache is gone
+	OptThumbSizes(64)
+	defer OptThumbSizes(128, 512)
+	path, err := us.Thumbnail(fi.ID(), 64)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(!fd.FileExists(path), "%v", fmt.Errorf("thumbnail missing: %s", path))
+
+	// invalidated on delete
+	lk.FailOnErr("%v", us.DelFileItem(fi.ID()))
+	lk.FailOnErrWhen(fd.DirExists(us.artifactDir(fi)), "%v", fmt.Errorf("thumbnails remain after deleting"))
+}
@@ -0,0 +1,294 @@
+package filemgr
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+	fd "github.com/digisan/gotk/file-dir"
+	"github.com/jtguibas/cinema"
+)
+
+const (
+	FmtMP4  = "mp4"  // H.264 video & AAC audio, plays in all browsers
+	FmtWebM = "webm" // VP9 video & Opus audio
+)
+
+// Rendition is one transcoding target of a video or audio. audio is rendered only as FmtMP3 or FmtOpus,
+// which take sound of video as well
+type Rendition struct {
+	Format    string     `json:"format"`              // FmtMP4, FmtWebM, FmtMP3 or FmtOpus
+	Height    int        `json:"height"`              // output height keeping aspect ratio, 0 or larger than original keeps original
+	Watermark *Watermark `json:"watermark,omitempty"` // drawn over frames before scaling, nil for none
+}
+
+// audio only output
+func (r Rendition) audio() bool {
+	return In(r.Format, FmtMP3, FmtOpus)
+}
+
+func (r Rendition) validate() error {
+	if NotIn(r.Format, FmtMP4, FmtWebM, FmtMP3, FmtOpus) {
+		return fmt.Errorf("rendition format [%s] is unsupported, only [%s %s %s %s]", r.Format, FmtMP4, FmtWebM, FmtMP3, FmtOpus)
+	}
+	if r.Height < 0 {
+		return fmt.Errorf("rendition height [%d] is invalid", r.Height)
+	}
+	if r.Watermark != nil {
+		return r.Watermark.validate()
+	}
+	return nil
+}
+
+// copy of 'r' with watermark image resolved in 'us'
+func (r Rendition) resolve(us *UserSpace) (Rendition, error) {
+	var err error
+	r.Watermark, err = r.Watermark.resolve(us)
+	return r, err
+}
+
+// sudo apt install ffmpeg
+// ffmpeg arguments transcoding 'in' of 'height' to 'out' as 'r', 'mark' is full frame overlay image if not empty.
+// progress is written to stdout
+func (r Rendition) args(in, out string, height int, mark string) []string {
+	args := []string{"-y", "-nostdin", "-i", in}
+	if r.audio() {
+		args = append(args, "-vn", "-map_metadata", "0")
+		switch r.Format {
+		case FmtOpus:
+			args = append(args, "-c:a", "libopus", "-b:a", "96k")
+		default:
+			args = append(args, "-c:a", "libmp3lame", "-q:a", "2", "-id3v2_version", "3")
+		}
+		return append(args, "-progress", "pipe:1", "-nostats", out)
+	}
+	scale := ""
+	if r.Height > 0 && r.Height < height {
+		scale = fmt.Sprintf("scale=-2:%d", r.Height/2*2)
+	}
+	switch {
+	case mark != "":
+		graph := "[0:v][1:v]overlay=0:0"
+		if scale != "" {
+			graph += "," + scale
+		}
+		args = append(args, "-i", mark, "-filter_complex", graph+"[out]", "-map", "[out]", "-map", "0:a?")
+	case scale != "":
+		args = append(args, "-vf", scale)
+	}
+	switch r.Format {
+	case FmtWebM:
+		args = append(args, "-c:v", "libvpx-vp9", "-crf", "32", "-b:v", "0", "-c:a", "libopus", "-b:a", "96k")
+	default:
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart")
+	}
+	return append(args, "-progress", "pipe:1", "-nostats", out)
+}
+
+// read ffmpeg '-progress' output, report percentage of 'duration'
+func readProgress(r io.Reader, duration time.Duration, progress func(pct float64)) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, val, ok := strings.Cut(sc.Text(), "=")
+		if !ok || progress == nil || duration <= 0 {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms": // both are microseconds
+			if us, err := strconv.ParseInt(val, 10, 64); err == nil {
+				progress(min(100, float64(us)*100/float64(duration.Microseconds())))
+			}
+		case "progress":
+			if val == "end" {
+				progress(100)
+			}
+		}
+	}
+}
+
+// run ffmpeg with 'args' ending with '-progress pipe:1', report percentage of 'duration'
+func runFFmpeg(args []string, duration time.Duration, progress func(pct float64)) error {
+	cmd := exec.Command("ffmpeg", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	readProgress(stdout, duration, progress)
+	if err := cmd.Wait(); err != nil {
+		msg := stderr.String()
+		if len(msg) > 512 {
+			msg = msg[len(msg)-512:]
+		}
+		return fmt.Errorf("ffmpeg failed: %v, %s", err, msg)
+	}
+	return nil
+}
+
+// transcode video 'in' to 'out' as 'r'
+func transcode(in, out string, r Rendition, progress func(pct float64)) error {
+	video, err := cinema.Load(in)
+	if err != nil {
+		return err
+	}
+	mark := ""
+	if r.Watermark != nil && !r.audio() {
+		if mark, err = r.Watermark.frameOverlay(video.Width(), video.Height()); err != nil {
+			return err
+		}
+		defer os.Remove(mark)
+	}
+	return runFFmpeg(r.args(in, out, video.Height(), mark), video.Duration(), progress)
+}
+
+// first FileItem matching 'id' able to be transcoded as 'rs', defaults of its type if 'rs' is empty
+func (us *UserSpace) transcodeSource(id string, rs []Rendition) (*fdb.FileItem, []Rendition, error) {
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(fis) == 0 {
+		return nil, nil, fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	fi := fis[0]
+	switch fi.Type() {
+	case fd.Video:
+		if len(rs) == 0 {
+			rs = opt.renditions
+		}
+	case fd.Audio:
+		if len(rs) == 0 {
+			rs = []Rendition{{Format: FmtMP3}}
+		}
+		for _, r := range rs {
+			if !r.audio() {
+				return nil, nil, fmt.Errorf("[%s] is audio, only [%s %s] can be rendered", id, FmtMP3, FmtOpus)
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("[%s] is %s, only video & audio can be transcoded", id, fi.Type())
+	}
+	for _, r := range rs {
+		if err := r.validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return fi, rs, nil
+}
+
+// Transcode renders 'rs' of first video or audio FileItem matching 'id' as new FileItems in same groups,
+// linked to the original by 'SrcId'. 'rs' defaults to 'OptRenditions' for video, FmtMP3 for audio.
+// 'progress' receives overall percentage, can be nil.
+func (us *UserSpace) Transcode(id string, progress func(pct float64), rs ...Rendition) (renditions []*fdb.FileItem, err error) {
+	fi, rs, err := us.transcodeSource(id, rs)
+	if err != nil {
+		return nil, err
+	}
+	return us.renderRenditions(fi, rs, progress)
+}
+
+// file name of rendition 'r' of 'fi'
+func renditionName(fi *fdb.FileItem, r Rendition) string {
+	base := strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+	if r.Height > 0 && !r.audio() {
+		return fmt.Sprintf("%s-%dp.%s", base, r.Height, r.Format)
+	}
+	return fmt.Sprintf("%s.%s", base, r.Format)
+}
+
+// renditions already saved for 'fi' are reused, so retrying after a failed one does NOT duplicate them.
+// 'us' needs NOT be loaded
+func (us *UserSpace) renderRenditions(fi *fdb.FileItem, rs []Rendition, progress func(pct float64)) (renditions []*fdb.FileItem, err error) {
+	saved, err := fdb.ListFileItems(func(e *fdb.FileItem) bool {
+		return e.SrcId == fi.Id && us.Own(e) && fd.FileExists(e.Path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	addYM := rYM.MatchString(strings.Split(strings.TrimPrefix(fi.Path, us.UserPath), PS)[0])
+	groups := []string{}
+	if fi.GroupList != "" {
+		groups = strings.Split(fi.GroupList, fdb.SEP_GRP)
+	}
+	for i, r := range rs {
+		if r, err = r.resolve(us); err != nil {
+			return renditions, err
+		}
+		name := renditionName(fi, r)
+		if done := Filter(saved, func(_ int, e *fdb.FileItem) bool { return e.Name() == name }); len(done) > 0 {
+			renditions = append(renditions, us.adoptFI(done[0]))
+			if progress != nil {
+				progress(float64(i+1) * 100 / float64(len(rs)))
+			}
+			continue
+		}
+
+		tmp, err := os.CreateTemp("", "filemgr-transcode-*."+r.Format)
+		if err != nil {
+			return renditions, err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+
+		err = transcode(fi.Path, tmp.Name(), r, func(pct float64) {
+			if progress != nil {
+				progress((float64(i) + pct/100) * 100 / float64(len(rs)))
+			}
+		})
+		if err != nil {
+			return renditions, err
+		}
+
+		f, err := os.Open(tmp.Name())
+		if err != nil {
+			return renditions, err
+		}
+		rfi, err := us.saveFile(f, name, "", nil, time.Now(), addYM, groups...)
+		f.Close()
+		if err != nil {
+			return renditions, err
+		}
+		if err := us.editFI(rfi, func(e *fdb.FileItem) error {
+			e.SrcId = fi.Id
+			return nil
+		}, false); err != nil {
+			return renditions, err
+		}
+		renditions = append(renditions, rfi)
+	}
+	return renditions, nil
+}
+
+// QueueTranscode queues transcoding of first video or audio FileItem matching 'id' in background, job reports progress
+func (us *UserSpace) QueueTranscode(id string, rs ...Rendition) (*fdb.Job, error) {
+	fi, rs, err := us.transcodeSource(id, rs)
+	if err != nil {
+		return nil, err
+	}
+	return us.queue(fi, JobTranscode, rs)
+}
+
+// renditions are saved as new FileItems in DB, loaded user spaces find them by 'Derived'
+func runTranscodeJob(us *UserSpace, fi *fdb.FileItem, args string, progress func(pct float64)) error {
+	rs := []Rendition{}
+	if err := json.Unmarshal([]byte(args), &rs); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	_, err := us.renderRenditions(fi, rs, progress)
+	return err
+}
@@ -0,0 +1,109 @@
+package filemgr
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/digisan/file-mgr/fdb"
+	lk "github.com/digisan/logkit"
+)
+
+// fake ffprobe & ffmpeg in PATH, ffmpeg reports progress then copies input to output or HLS segment
+func fakeFFmpeg(t *testing.T) {
+	dir := t.TempDir()
+	probe := `#!/bin/sh
+echo '{"streams":[{"width":1280,"height":720}],"format":{"duration":"10.0","bit_rate":"1000000"}}'
+`
+	mpeg := `#!/bin/sh
+in=""
+prev=""
+for a in "$@"; do
+	[ "$prev" = "-i" ] && in="$a"
+	prev="$a"
+	out="$a"
+done
+echo "out_time_us=5000000"
+echo "progress=continue"
+echo "out_time_us=10000000"
+echo "progress=end"
+case "$out" in
+*.m3u8)
+	printf '#EXTM3U\n#EXTINF:6.0,\nseg-000.ts\n#EXT-X-ENDLIST\n' > "$out"
+	cp "$in" "$(dirname "$out")/seg-000.ts"
+	;;
+*)
+	cp "$in" "$out"
+	;;
+esac
+`
+	lk.FailOnErr("%v", os.WriteFile(filepath.Join(dir, "ffprobe"), []byte(probe), 0o755))
+	lk.FailOnErr("%v", os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(mpeg), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestTranscode(t *testing.T) {
+
+	fakeFFmpeg(t)
+	InitFileMgr("./data")
+
+	us, err := UseUser("transcode test")
+	lk.FailOnErr("%v", err)
+
+	_, err = us.SaveFile(bytes.NewReader(largeMedia(1<<20)), "clip.mp4", "", nil, false, "media")
+	lk.FailOnErr("%v", err)
+	src := us.FIs[len(us.FIs)-1]
+
+	args := Rendition{Format: FmtMP4, Height: 480}.args("in.mp4", "out.mp4", 720, "")
+	fmt.Println(args)
+	lk.FailOnErrWhen(args[5] != "scale=-2:480", "%v", fmt.Errorf("expected scale filter, got %v", args))
+	args = Rendition{Format: FmtWebM, Height: 1080}.args("in.mp4", "out.webm", 720, "")
+	lk.FailOnErrWhen(args[4] == "-vf", "%v", fmt.Errorf("MUST NOT upscale, got %v", args))
+
+	_, err = us.Transcode(src.Id, nil, Rendition{Format: "avi"})
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("avi should be rejected"))
+
+	pcts := []float64{}
+	rs, err := us.Transcode(src.Id, func(pct float64) { pcts = append(pcts, pct) },
+		Rendition{Format: FmtMP4, Height: 480}, Rendition{Format: FmtWebM})
+	lk.FailOnErr("%v", err)
+	fmt.Println(pcts)
+	lk.FailOnErrWhen(len(rs) != 2, "%v", fmt.Errorf("expected 2 renditions, got %d", len(rs)))
+	lk.FailOnErrWhen(len(pcts) == 0 || pcts[0] != 25 || pcts[len(pcts)-1] != 100, "%v", fmt.Errorf("progress %v", pcts))
+	lk.FailOnErrWhen(rs[0].Name() != "clip-480p.mp4" || rs[1].Name() != "clip.webm", "%v",
+		fmt.Errorf("names %s %s", rs[0].Name(), rs[1].Name()))
+
+	derived, err := us.Derived(src.Id)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(derived) != 2, "%v", fmt.Errorf("expected 2 derived, got %d", len(derived)))
+
+	// saved renditions are reused, NOT duplicated
+	again, err := us.Transcode(src.Id, nil, Rendition{Format: FmtWebM}, Rendition{Format: FmtMP3})
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(again) != 2 || again[0] != rs[1] || again[1].Name() != "clip.mp3", "%v", fmt.Errorf("reused %v", again))
+	derived, err = us.Derived(src.Id)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(derived) != 3, "%v", fmt.Errorf("expected 3 derived, got %d", len(derived)))
+
+	// background
+	lk.FailOnErr("%v", StartJobs(1))
+	defer StopJobs()
+
+	job, err := us.QueueTranscode(src.Id)
+	lk.FailOnErr("%v", err)
+	jobs := waitJobs(us, src.Id)
+	for _, j := range jobs {
+		if j.Id == job.Id {
+			fmt.Println(j)
+			lk.FailOnErrWhen(j.Status != fdb.JobDone || j.Progress != 100, "%v", fmt.Errorf("job %s %d%% %s", j.Status, j.Progress, j.Err))
+		}
+	}
+	// live session sees renditions of background job
+	derived, err = us.Derived(src.Id)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(derived) != 4, "%v", fmt.Errorf("expected 4 derived, got %d", len(derived)))
+	fis, err := us.FileItems(derived[3].Id)
+	lk.FailOnErrWhen(err != nil || len(fis) != 1 || fis[0].Name() != "clip-720p.mp4", "%v", fmt.Errorf("job rendition NOT in memory: %v %v", fis, err))
+}
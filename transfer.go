@@ -0,0 +1,160 @@
+package filemgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+// storage path in 'dst' for 'fi' of 'us', keeping its year-month directory, type & file name
+func (us *UserSpace) pathIn(dst *UserSpace, fi *fdb.FileItem, groups []string) string {
+	path := dst.UserPath
+	if seg := strings.Split(strings.TrimPrefix(fi.Path, us.UserPath), PS)[0]; rYM.MatchString(seg) {
+		path = filepath.Join(path, seg)
+	}
+	return filepath.Join(path, filepath.Join(groups...), fi.Type(), filepath.Base(fi.Path))
+}
+
+// "md5-unixmilli", unique in DB
+func newFileItemId(hash string, tm time.Time) string {
+	ms := tm.UnixMilli()
+	for {
+		id := strings.ToLower(fmt.Sprintf("%s-%v", hash, ms))
+		if !fdb.IsExisting(id) {
+			return id
+		}
+		ms++
+	}
+}
+
+// FileItems matching 'id' to copy or 'move' into 'dst', all of them MUST be accepted by policy & quota of 'dst'
+func (us *UserSpace) checkOut(id string, dst *UserSpace, groups []string, move bool) ([]*fdb.FileItem, error) {
+	if dst == nil {
+		return nil, fmt.Errorf("target user space is nil")
+	}
+	if err := validGroups(groups...); err != nil {
+		return nil, err
+	}
+	fis, err := us.FileItems(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) == 0 {
+		return nil, fmt.Errorf("[%s] %w", id, os.ErrNotExist)
+	}
+	pending := int64(0) // bytes of previous FileItems
+	for _, fi := range fis {
+		if target := us.pathIn(dst, fi, groups); fd.FileExists(target) {
+			return nil, fmt.Errorf("[%s] already exists", target)
+		}
+		info, err := os.Stat(fi.Path)
+		if err != nil {
+			return nil, err
+		}
+		if move && dst.UName == us.UName {
+			pending -= info.Size() // moving within user space adds nothing
+		}
+		if _, err := dst.admit(fi.Name(), fi.Type(), info.Size(), pending); err != nil {
+			return nil, err
+		}
+		pending += info.Size()
+	}
+	return fis, nil
+}
+
+// CopyTo copies FileItems matching 'id' into 'dst' under 'groups', keeping note, time, metadata & source with new ids.
+// Policy & quota of 'dst' apply. User spaces have no ACL, caller authorizes access to both of them.
+// Each copy is committed to DB only after its file is copied, a failed DB update removes the copied file.
+func (us *UserSpace) CopyTo(id string, dst *UserSpace, groups ...string) (copies []*fdb.FileItem, err error) {
+	fis, err := us.checkOut(id, dst, groups, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		hash, err := contentHash(fi)
+		if err != nil {
+			return copies, err
+		}
+		cp := &fdb.FileItem{
+			Id:        newFileItemId(hash, time.Now()),
+			Path:      us.pathIn(dst, fi, groups),
+			Tm:        fi.Tm,
+			GroupList: strings.Join(groups, fdb.SEP_GRP),
+			Note:      fi.Note,
+			OriName:   fi.OriName,
+			DispName:  fi.DispName,
+			SrcId:     fi.SrcId,
+			Meta:      fi.Meta,
+		}
+		fd.MustCreateDir(filepath.Dir(cp.Path))
+		if err := fd.CopyFile(fi.Path, cp.Path); err != nil {
+			os.Remove(cp.Path)
+			return copies, err
+		}
+		if text := us.textPath(fi); fd.FileExists(text) {
+			fd.MustCreateDir(dst.artifactDir(cp))
+			lk.WarnOnErr("%v", fd.CopyFile(text, dst.textPath(cp)))
+		}
+		if err := fdb.ReplaceFileItems(nil, []*fdb.FileItem{cp}); err != nil {
+			os.Remove(cp.Path)
+			return copies, err
+		}
+		dst.FIs = append(dst.FIs, cp)
+		dst.IDs[cp.Id+cp.Path] = struct{}{}
+		copies = append(copies, cp)
+	}
+	return copies, nil
+}
+
+// TransferTo moves FileItems matching 'id' & their jobs into 'dst' under 'groups', keeping id, note & time.
+// Policy & quota of 'dst' apply. User spaces have no ACL, caller authorizes access to both of them.
+// Each file is moved back if its DB update fails.
+func (us *UserSpace) TransferTo(id string, dst *UserSpace, groups ...string) (moved []*fdb.FileItem, err error) {
+	fis, err := us.checkOut(id, dst, groups, true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if len(moved) > 0 {
+			if errChk := us.SelfCheck(true); err == nil {
+				err = errChk
+			}
+		}
+	}()
+	for _, fi := range fis {
+		mv := *fi
+		mv.Path = us.pathIn(dst, fi, groups)
+		mv.GroupList = strings.Join(groups, fdb.SEP_GRP)
+
+		fd.MustCreateDir(filepath.Dir(mv.Path))
+		if err := os.Rename(fi.Path, mv.Path); err != nil {
+			return moved, err
+		}
+		if err := fdb.ReplaceFileItems([]*fdb.FileItem{fi}, []*fdb.FileItem{&mv}); err != nil {
+			if errBack := os.Rename(mv.Path, fi.Path); errBack != nil {
+				return moved, fmt.Errorf("%w, and moving file back failed: %v", err, errBack)
+			}
+			return moved, err
+		}
+		if art := us.artifactDir(fi); fd.DirExists(art) {
+			fd.MustCreateDir(filepath.Join(dst.UserPath, ArtDir))
+			lk.WarnOnErr("%v", os.Rename(art, dst.artifactDir(&mv)))
+		}
+		us.dropMemFI(fi)
+		dst.FIs = append(dst.FIs, &mv)
+		dst.IDs[mv.Id+mv.Path] = struct{}{}
+		moved = append(moved, &mv)
+
+		if _, err := fdb.EditJobs(func(job *fdb.Job) bool { return job.FiId == fi.Id && job.User == us.UName },
+			func(job *fdb.Job) { job.User = dst.UName }); err != nil {
+			return moved, fmt.Errorf("[%s] is transferred, but its jobs are NOT: %w", fi.Id, err)
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,74 @@
+package filemgr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+func TestCopyTransfer(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	tag := time.Now().UnixNano()
+	src, err := UseUser(fmt.Sprint("transfer src ", tag))
+	lk.FailOnErr("%v", err)
+	dst, err := UseUser(fmt.Sprint("transfer dst ", tag))
+	lk.FailOnErr("%v", err)
+
+	_, err = src.SaveFile(strings.NewReader(fmt.Sprint("transfer ", tag)), "t.txt", "keep me", nil, true, "G0")
+	lk.FailOnErr("%v", err)
+	fi := src.FIs[len(src.FIs)-1]
+
+	// copy, new id, same note & time
+	copies, err := src.CopyTo(fi.ID(), dst, "shared")
+	lk.FailOnErr("%v", err)
+	cp := copies[0]
+	fmt.Println(cp)
+	lk.FailOnErrWhen(cp.Id == fi.Id || cp.Note != fi.Note || !cp.Tm.Equal(fi.Tm) || cp.GroupList != "shared", "%v", fmt.Errorf("copy: %v", cp))
+	lk.FailOnErrWhen(!cp.HasMeta() || cp.Meta != fi.Meta, "%v", fmt.Errorf("copy lost metadata: %v", cp.Meta))
+	lk.FailOnErrWhen(!fd.FileExists(fi.Path) || !dst.Own(cp) || !fdb.IsExisting(cp.Id), "%v", fmt.Errorf("copy is incomplete"))
+
+	_, err = src.CopyTo(fi.ID(), dst, "shared")
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("copy overwrote existing file"))
+
+	// quota of target counts files already there
+	lk.FailOnErr("%v", dst.SetPolicy(&Policy{MaxTotal: cp.Size() + 1}))
+	_, err = src.CopyTo(fi.ID(), dst, "again")
+	lk.FailOnErrWhen(!rejected(err, RejectQuota), "%v", fmt.Errorf("copy over quota MUST be rejected, got %v", err))
+	_, err = dst.SaveFile(strings.NewReader("xx"), "more.txt", "", nil, false, "G0")
+	lk.FailOnErrWhen(!rejected(err, RejectQuota) && !rejected(err, RejectSize), "%v", fmt.Errorf("save over quota MUST be rejected, got %v", err))
+	_, err = dst.TransferTo(cp.ID(), dst, "moved")
+	lk.FailOnErr("%v", err)
+	lk.FailOnErr("%v", dst.SetPolicy(nil))
+
+	// transfer, same id & jobs
+	_, err = src.queue(fi, JobThumbnail, nil)
+	lk.FailOnErr("%v", err)
+	moved, err := src.TransferTo(fi.ID(), dst, "handed", "over")
+	lk.FailOnErr("%v", err)
+	mv := moved[0]
+	fmt.Println(mv)
+	lk.FailOnErrWhen(mv.Id != fi.Id || mv.Note != fi.Note || !mv.Tm.Equal(fi.Tm), "%v", fmt.Errorf("transfer: %v", mv))
+	lk.FailOnErrWhen(fd.FileExists(fi.Path) || !fd.FileExists(mv.Path), "%v", fmt.Errorf("file not moved"))
+	lk.FailOnErrWhen(len(src.FIs) != 0 || len(dst.FIs) != 2, "%v", fmt.Errorf("memory: %d %d", len(src.FIs), len(dst.FIs)))
+
+	dbFI, ok, err := fdb.FirstFileItem(fi.Id)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(!ok || !dst.Own(dbFI), "%v", fmt.Errorf("DB not updated: %v", dbFI))
+	jobs, err := fdb.ListJobs(func(job *fdb.Job) bool { return job.FiId == fi.Id })
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(jobs) != 1 || jobs[0].User != dst.UName, "%v", fmt.Errorf("jobs NOT transferred: %v", jobs))
+
+	reloaded, err := UseUser(dst.UName)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(reloaded.FIs) != 2, "%v", fmt.Errorf("reloaded: %d", len(reloaded.FIs)))
+
+	lk.FailOnErr("%v", EraseUser(src.UName))
+	lk.FailOnErr("%v", EraseUser(dst.UName))
+}
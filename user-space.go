@@ -1,6 +1,7 @@
 package filemgr
 
 import (
+	"bytes"
 	"crypto/md5"
 	"errors"
 	"fmt"
@@ -17,10 +18,12 @@ import (
 	fd "github.com/digisan/gotk/file-dir"
 	"github.com/digisan/gotk/strs"
 	lk "github.com/digisan/logkit"
+	"github.com/google/uuid"
 )
 
 const (
-	PS = string(os.PathSeparator)
+	PS     = string(os.PathSeparator)
+	ArtDir = ".fi" // directory under user space for files derived from FileItems, such as thumbnails
 )
 
 var (
@@ -31,15 +34,32 @@ var (
 /////////////////////////////////////////////////////////////////////////////
 
 var opt = struct {
-	chkOnLoad    bool
-	chkOnSave    bool
-	chkOnSetNote bool
-	chkOnSetGrp  bool
+	chkOnLoad     bool
+	chkOnSave     bool
+	chkOnSetNote  bool
+	chkOnSetGrp   bool
+	thumbOnSave   bool
+	thumbSizes    []int
+	jobTries      int
+	jobRetryDelay time.Duration
+	jobKeep       time.Duration
+	renditions    []Rendition
+	hlsVariants   []HLSVariant
+	stripPrivate  bool
+	policy        *Policy
+	markDir       string
 }{
-	chkOnLoad:    true,
-	chkOnSave:    true,
-	chkOnSetNote: false,
-	chkOnSetGrp:  false,
+	chkOnLoad:     true,
+	chkOnSave:     true,
+	chkOnSetNote:  false,
+	chkOnSetGrp:   false,
+	thumbOnSave:   true,
+	thumbSizes:    []int{128, 512},
+	jobTries:      3,
+	jobRetryDelay: 10 * time.Second,
+	jobKeep:       24 * time.Hour,
+	renditions:    []Rendition{{Format: FmtMP4, Height: 720}},
+	hlsVariants:   []HLSVariant{{Height: 360, Bitrate: 800}, {Height: 720, Bitrate: 2800}},
 }
 
 func OptCheckOnLoad(v bool) {
@@ -58,6 +78,41 @@ func OptCheckNoSetGrp(v bool) {
 	opt.chkOnSetGrp = v
 }
 
+func OptThumbOnSave(v bool) {
+	opt.thumbOnSave = v
+}
+
+// failed job is retried until 'tries' runs, waiting 'delay' times number of runs
+func OptJobRetry(tries int, delay time.Duration) {
+	opt.jobTries = max(1, tries)
+	opt.jobRetryDelay = delay
+}
+
+// finished jobs are purged 'keep' after they finish, failed ones can be retried until then
+func OptJobKeep(keep time.Duration) {
+	opt.jobKeep = max(0, keep)
+}
+
+// default renditions of 'Transcode'
+func OptRenditions(rs ...Rendition) {
+	opt.renditions = rs
+}
+
+// privacy mode, location & device tags of EXIF, and XMP are stripped from saved images. capture time is kept
+func OptStripPrivate(strip bool) {
+	opt.stripPrivate = strip
+}
+
+// default bitrate levels of 'PackageHLS'
+func OptHLSVariants(vs ...HLSVariant) {
+	opt.hlsVariants = vs
+}
+
+// longest edges in pixels of thumbnails, non-positive sizes are ignored
+func OptThumbSizes(sizes ...int) {
+	opt.thumbSizes = Filter(sizes, func(i, e int) bool { return e > 0 })
+}
+
 /////////////////////////////////////////////////////////////////////////////
 
 type UserSpace struct {
@@ -97,6 +152,24 @@ func DisposeFileMgr() {
 	fdb.CloseDB()
 }
 
+// all user names having a space under root
+func ListUsers() ([]string, error) {
+	if !fd.DirExists(rootSP) {
+		return []string{}, nil
+	}
+	entries, err := os.ReadDir(rootSP)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
 func UseUser(name string) (*UserSpace, error) {
 	us := &UserSpace{
 		UName: name,
@@ -107,8 +180,7 @@ func UseUser(name string) (*UserSpace, error) {
 }
 
 func (us *UserSpace) init() *UserSpace {
-	us.UserPath = filepath.Join(rootSP, us.UName)
-	us.UserPath = strings.TrimSuffix(us.UserPath, PS) + PS
+	us.UserPath = userPath(us.UName)
 	if !fd.DirExists(us.UserPath) {
 		fd.MustCreateDir(us.UserPath)
 	}
@@ -146,17 +218,70 @@ func (us *UserSpace) UpdateFileItem(fi *fdb.FileItem, selfCheck bool) error {
 			lk.FailOnErr("%v", us.SelfCheck(false))
 		}
 	}()
-	if us.Own(fi) {
-		return fdb.UpdateFileItem(fi)
+	if !us.Own(fi) {
+		return fmt.Errorf("%v does NOT belong to %v", *fi, *us)
 	}
-	return fmt.Errorf("%v does NOT belong to %v", *fi, *us)
+	// a background job may have replaced stored file since 'fi' was loaded, keep its result
+	if cur, ok, err := fdb.FirstFileItem(fi.Id); err == nil && ok && cur.Id == fi.Id &&
+		cur.Path != fi.Path && !fd.FileExists(fi.Path) && fd.FileExists(cur.Path) {
+		delete(us.IDs, fi.Id+fi.Path)
+		fi.Path, fi.Meta = cur.Path, cur.Meta
+		if fi.DispName == "" {
+			fi.DispName = cur.DispName
+		}
+		us.IDs[fi.Id+fi.Path] = struct{}{}
+	}
+	return fdb.UpdateFileItem(fi)
 }
 
-// return storage path & error
-func (us *UserSpace) SaveFile(r io.Reader, fName, note string, addYM bool, groups ...string) (string, error) {
+// apply 'edit' to DB record of 'fi', then 'fi' takes the record. Other fields of the record are kept,
+// as background jobs may have updated them since 'fi' was loaded
+func (us *UserSpace) editFI(fi *fdb.FileItem, edit func(fi *fdb.FileItem) error, selfCheck bool) error {
+	if !us.Own(fi) {
+		return fmt.Errorf("%v does NOT belong to %v", *fi, *us)
+	}
+	cur, err := fdb.EditFileItem(fi.Id, edit)
+	if err != nil {
+		return err
+	}
+	us.setMemFI(fi, cur)
+	if selfCheck {
+		return us.SelfCheck(false)
+	}
+	return nil
+}
 
-	now := time.Now()
+// 'fi' takes its DB record, such as after a background job updated it
+func (us *UserSpace) syncMemFI(fi *fdb.FileItem) error {
+	cur, ok, err := fdb.FirstFileItem(fi.Id)
+	if err != nil || !ok || cur.Id != fi.Id {
+		return err
+	}
+	us.setMemFI(fi, cur)
+	return nil
+}
 
+// pointer of 'fi' held by callers stays valid
+func (us *UserSpace) setMemFI(fi, cur *fdb.FileItem) {
+	delete(us.IDs, fi.Id+fi.Path)
+	*fi = *cur
+	us.IDs[fi.Id+fi.Path] = struct{}{}
+}
+
+// add 'fi' stored by a background job to memory, return the one in memory if already loaded
+func (us *UserSpace) adoptFI(fi *fdb.FileItem) *fdb.FileItem {
+	for _, e := range us.FIs {
+		if e.Id == fi.Id {
+			return e
+		}
+	}
+	us.FIs = append(us.FIs, fi)
+	us.IDs[fi.Id+fi.Path] = struct{}{}
+	return fi
+}
+
+// "name.ext" => "name-unix-random.ext", unique even for same name saved in same second
+func storedName(fName string, now time.Time) string {
 	base, ext := "", ""
 	if !strings.Contains(fName, ".") {
 		base, ext = fName, ""
@@ -164,88 +289,167 @@ func (us *UserSpace) SaveFile(r io.Reader, fName, note string, addYM bool, group
 		ext = strs.SplitPartFromLastTo[string](fName, ".", 1)
 		base = strs.SplitPartFromLastTo[string](fName, ".", 2)
 	}
-	fName = fmt.Sprintf("%s-%v.%s", base, now.Unix(), ext)
-	fName = strings.TrimSuffix(fName, ".")
+	fName = fmt.Sprintf("%s-%v-%s.%s", base, now.Unix(), strings.Split(uuid.New().String(), "-")[0], ext)
+	return strings.TrimSuffix(fName, ".")
+}
+
+// groups are directories under user space, so they must NOT escape it or clash with separators & artifacts
+func validGroups(groups ...string) error {
+	for _, grp := range groups {
+		if grp == "" || grp == "." || grp == ".." || grp == ArtDir || strings.ContainsAny(grp, `/\`) || strings.Contains(grp, fdb.SEP_GRP) {
+			return fmt.Errorf("invalid group [%s]", grp)
+		}
+	}
+	return nil
+}
+
+// 'po' nil saves file as it is, return storage path & error
+func (us *UserSpace) SaveFile(r io.Reader, fName, note string, po *ProcessOptions, addYM bool, groups ...string) (string, error) {
+	fi, err := us.saveFile(r, fName, note, po, time.Now(), addYM, groups...)
+	if fi == nil {
+		return "", err
+	}
+	return fi.Path, err
+}
+
+// 'now' is FileItem time, also decides year-month directory
+func (us *UserSpace) saveFile(r io.Reader, fName, note string, po *ProcessOptions, now time.Time, addYM bool, groups ...string) (fi *fdb.FileItem, err error) {
+
+	if err := validGroups(groups...); err != nil {
+		return nil, err
+	}
+	if po, err = po.resolve(us); err != nil {
+		return nil, err
+	}
+	oriName := filepath.Base(fName)
+	fName = storedName(oriName, now)
+
+	// policy is checked on content head before anything is written, type sniffed from it is stored as well
+	head := make([]byte, sniffHead)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	fType := fd.FileType(bytes.NewReader(head))
+	limit, err := us.admit(oriName, fType, -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	r = io.MultiReader(bytes.NewReader(head), r)
+	if limit >= 0 {
+		r = io.LimitReader(r, limit+1)
+	}
 
 	// /root/name/group0/.../groupX/type/file
 	grpPath := filepath.Join(groups...)         // /group0/.../groupX/
 	path := filepath.Join(us.UserPath, grpPath) // /root/name/group0/.../groupX/
 	if addYM {
-		path = filepath.Join(us.UserPath, time.Now().Format("2006-01"), grpPath) // /root/name/2006-01/group0/.../groupX/
+		path = filepath.Join(us.UserPath, now.Format("2006-01"), grpPath) // /root/name/2006-01/group0/.../groupX/
 	}
+	typePath := filepath.Join(path, fType) // /root/name/2006-01/group0/.../groupX/type/
+
+	// nothing is left on disk if saving fails from here, artifacts such as extracted text included
+	cur, art, saved := "", "", false
+	defer func() {
+		if err != nil && !saved {
+			if cur != "" {
+				os.Remove(cur)
+			}
+			if art != "" {
+				os.RemoveAll(art)
+				pruneDirs(filepath.Dir(art), us.UserPath)
+			}
+			pruneDirs(typePath, us.UserPath)
+			pruneDirs(path, us.UserPath)
+		}
+	}()
+
 	fd.MustCreateDir(path)                // mkdir /root/name/2006-01/group0/.../groupX/
 	oldPath := filepath.Join(path, fName) // /root/name/2006-01/group0/.../groupX/file
 	oldFile, err := os.Create(oldPath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer oldFile.Close()
-	if _, err = io.Copy(oldFile, r); err != nil {
-		return "", err
+	cur = oldPath
+	written, err := io.Copy(oldFile, r)
+	oldFile.Close()
+	if err == nil && limit >= 0 && written > limit {
+		err = &PolicyError{oriName, RejectSize, fmt.Sprintf("content exceeds %d bytes", limit)}
 	}
-
-	oldRdr, err := os.Open(oldPath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	fType := fd.FileType(oldRdr)
-	defer oldRdr.Close()
 
-	// further process after uploading
-	if strings.Contains(note, "crop:") {
-		switch fType {
-		case fd.Video:
-			if p, err := videoCrop(oldPath, note); err == nil && len(p) != 0 {
-				if err := os.RemoveAll(oldPath); err != nil {
-					return "", err
-				}
-				oldPath = p
-				fName = filepath.Base(p)
-			}
-		case fd.Image:
-			if p, err := imageCrop(oldPath, note, "png"); err == nil && len(p) != 0 {
-				if err := os.RemoveAll(oldPath); err != nil {
-					return "", err
-				}
-				oldPath = p
-				fName = filepath.Base(p)
-			}
+	if opt.stripPrivate && fType == fd.Image {
+		if err := stripPrivate(oldPath); err != nil {
+			return nil, err
 		}
 	}
 
-	newPath := filepath.Join(path, fType)   // /root/name/2006-01/group0/.../groupX/type/
-	fd.MustCreateDir(newPath)               // /root/name/2006-01/group0/.../groupX/type/
-	newPath = filepath.Join(newPath, fName) // /root/name/2006-01/group0/.../groupX/type/file
-
-	if err = os.Rename(oldPath, newPath); err == nil {
-		data, err := os.ReadFile(newPath)
+	// further process after uploading, in background if job workers are running
+	async := jobsRunning()
+	if !async {
+		p, err := process(oldPath, fType, po)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		fi := &fdb.FileItem{
-			Id:        strings.ToLower(fmt.Sprintf("%x-%v", md5.Sum(data), now.UnixMilli())), // sha1.Sum, sha256.Sum256
-			Path:      newPath,
-			Tm:        now,
-			GroupList: strings.Join(groups, fdb.SEP_GRP),
-			Note:      note,
+		if p != oldPath {
+			os.Remove(oldPath)
+			oldPath, cur = p, p
+			fName = filepath.Base(p)
 		}
-		if !us.hasMemFI(fi) {
-			if err = us.UpdateFileItem(fi, opt.chkOnSave); err == nil {
-				us.FIs = append(us.FIs, fi)
-			}
+	}
+
+	fd.MustCreateDir(typePath)                // /root/name/2006-01/group0/.../groupX/type/
+	newPath := filepath.Join(typePath, fName) // /root/name/2006-01/group0/.../groupX/type/file
+
+	if err = os.Rename(oldPath, newPath); err != nil {
+		return nil, err
+	}
+	cur = newPath
+	data, err := os.ReadFile(newPath)
+	if err != nil {
+		return nil, err
+	}
+	fi = &fdb.FileItem{
+		Id:        strings.ToLower(fmt.Sprintf("%x-%v", md5.Sum(data), now.UnixMilli())), // sha1.Sum, sha256.Sum256
+		Path:      newPath,
+		Tm:        now,
+		GroupList: strings.Join(groups, fdb.SEP_GRP),
+		Note:      note,
+		OriName:   oriName,
+		DispName:  convertedName(oriName, newPath),
+	}
+	art = us.artifactDir(fi)
+	if !async {
+		refreshMeta(fi)
+	}
+	if !us.hasMemFI(fi) {
+		if err = us.UpdateFileItem(fi, opt.chkOnSave); err != nil {
+			return nil, err
 		}
+		us.FIs = append(us.FIs, fi)
+		us.IDs[fi.Id+fi.Path] = struct{}{}
+	}
+	saved = true
+	switch {
+	case async:
+		err = us.queueOnSave(fi, po)
+	case opt.thumbOnSave:
+		lk.WarnOnErr("%v", us.renderThumbs(fi))
 	}
-	return newPath, err
+	return fi, err
 }
 
 // 'fh' --- FormFile("param"), return storage path & error
-func (us *UserSpace) SaveFormFile(fh *multipart.FileHeader, note string, addYM bool, groups ...string) (string, error) {
+func (us *UserSpace) SaveFormFile(fh *multipart.FileHeader, note string, po *ProcessOptions, addYM bool, groups ...string) (string, error) {
 	file, err := fh.Open()
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
-	return us.SaveFile(file, fh.Filename, note, addYM, groups...)
+	return us.SaveFile(file, fh.Filename, note, po, addYM, groups...)
 }
 
 func (us *UserSpace) Own(fi *fdb.FileItem) bool {
@@ -282,6 +486,22 @@ func (us *UserSpace) SelfCheck(rmEmptyDir bool) error {
 	return nil
 }
 
+// remove FileItems whose files are missing on disk, then remove empty directories
+func (us *UserSpace) Reconcile() (removed []*fdb.FileItem, err error) {
+	for _, fi := range us.FIs {
+		if !fd.FileExists(fi.Path) {
+			removed = append(removed, fi)
+		}
+	}
+	for _, fi := range removed {
+		if _, err = fdb.RemoveFileItems(fi.ID(), true); err != nil {
+			return removed, err
+		}
+		us.dropMemFI(fi)
+	}
+	return removed, us.SelfCheck(true)
+}
+
 func (us *UserSpace) SearchFileItem(fType string, groups ...string) (fis []*fdb.FileItem) {
 	regs := make([]*regexp.Regexp, 0, len(groups))
 	for _, grp := range groups {
@@ -353,6 +573,9 @@ func (us *UserSpace) DelFileItem(id string) error {
 		return err
 	}
 	for _, fi := range fis {
+		if err := us.syncMemFI(fi); err != nil {
+			return err
+		}
 		if _, err := fdb.RemoveFileItems(fi.ID(), true); err != nil {
 			lk.WarnOnErr("%v", err)
 			return err
@@ -361,17 +584,56 @@ func (us *UserSpace) DelFileItem(id string) error {
 			lk.WarnOnErr("%v", err)
 			return err
 		}
+		lk.WarnOnErr("%v", os.RemoveAll(us.artifactDir(fi)))
+		lk.WarnOnErr("%v", dropJobs(fi))
+		us.dropMemFI(fi)
+		if err := us.unlinkDerived(fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileItems derived from deleted 'fi' stay as independent ones
+func (us *UserSpace) unlinkDerived(fi *fdb.FileItem) error {
+	derived, err := fdb.ListFileItems(func(e *fdb.FileItem) bool { return e.SrcId == fi.Id })
+	if err != nil {
+		return err
+	}
+	for _, d := range derived {
+		cur, err := fdb.EditFileItem(d.Id, func(e *fdb.FileItem) error {
+			e.SrcId = ""
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range us.FIs {
+			if e.Id == cur.Id {
+				us.setMemFI(e, cur)
+			}
+		}
 	}
 	return nil
 }
 
+// "root/name/.fi/id/", derived files of 'fi'
+func (us *UserSpace) artifactDir(fi *fdb.FileItem) string {
+	return filepath.Join(us.UserPath, ArtDir, fi.Id)
+}
+
+func (us *UserSpace) dropMemFI(fi *fdb.FileItem) {
+	delete(us.IDs, fi.Id+fi.Path)
+	us.FIs = Filter(us.FIs, func(i int, e *fdb.FileItem) bool { return e != fi })
+}
+
 func (us *UserSpace) SetFINote(fId, note string) error {
-	for i, fi := range us.FIs {
+	for _, fi := range us.FIs {
 		if strings.HasPrefix(fi.ID(), fId) {
-			oriNote := us.FIs[i].Note
-			us.FIs[i].SetNote(note)
-			if err := us.UpdateFileItem(us.FIs[i], opt.chkOnSetNote); err != nil {
-				us.FIs[i].SetNote(oriNote)
+			if err := us.editFI(fi, func(fi *fdb.FileItem) error {
+				fi.SetNote(note)
+				return nil
+			}, opt.chkOnSetNote); err != nil {
 				return err
 			}
 		}
@@ -379,14 +641,30 @@ func (us *UserSpace) SetFINote(fId, note string) error {
 	return nil
 }
 
-func (us *UserSpace) SetFIGroup(fId string, iGrp int, nameGrp string) error {
-	for i, fi := range us.FIs {
+// SetFIName renames display name of FileItems, stored file is NOT moved
+func (us *UserSpace) SetFIName(fId, name string) error {
+	for _, fi := range us.FIs {
 		if strings.HasPrefix(fi.ID(), fId) {
-			_, err := us.FIs[i].SetGroup(iGrp, nameGrp)
-			if err != nil {
+			if err := us.editFI(fi, func(fi *fdb.FileItem) error {
+				return fi.SetName(name)
+			}, opt.chkOnSetNote); err != nil {
 				return err
 			}
-			if err = us.UpdateFileItem(us.FIs[i], opt.chkOnSetGrp); err != nil {
+		}
+	}
+	return nil
+}
+
+func (us *UserSpace) SetFIGroup(fId string, iGrp int, nameGrp string) error {
+	if err := validGroups(nameGrp); err != nil {
+		return err
+	}
+	for _, fi := range us.FIs {
+		if strings.HasPrefix(fi.ID(), fId) {
+			if err := us.editFI(fi, func(fi *fdb.FileItem) error {
+				_, err := fi.SetGroup(iGrp, nameGrp)
+				return err
+			}, opt.chkOnSetGrp); err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,169 @@
+package filemgr
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+)
+
+// UserStat is usage of one user space
+type UserStat struct {
+	Name      string         `json:"name"`
+	Files     int            `json:"files"`      // number of FileItems
+	Bytes     int64          `json:"bytes"`      // size of FileItems content
+	DiskBytes int64          `json:"disk_bytes"` // size of whole user space directory
+	Types     map[string]int `json:"types"`      // number of FileItems per type
+	Latest    time.Time      `json:"latest"`     // time of latest FileItem
+}
+
+// "root/name/"
+func userPath(name string) string {
+	return strings.TrimSuffix(filepath.Join(rootSP, name), PS) + PS
+}
+
+// UserSpace owning 'fi' by its path, NOT loaded, only for paths
+func ownerSpace(fi *fdb.FileItem) *UserSpace {
+	name := strings.Split(strings.TrimPrefix(fi.Path, userPath("")), PS)[0]
+	return &UserSpace{UName: name, UserPath: userPath(name), IDs: make(map[string]struct{})}
+}
+
+// FileItems of user 'name' in DB, no self check
+func userFileItems(name string) ([]*fdb.FileItem, error) {
+	path := userPath(name)
+	return fdb.ListFileItems(func(fi *fdb.FileItem) bool {
+		return strings.HasPrefix(fi.Path, path)
+	})
+}
+
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// ListUserStats lists all users with their usage
+func ListUserStats() ([]*UserStat, error) {
+	names, err := ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]*UserStat, 0, len(names))
+	for _, name := range names {
+		fis, err := userFileItems(name)
+		if err != nil {
+			return nil, err
+		}
+		stat := &UserStat{Name: name, Files: len(fis), Types: map[string]int{}}
+		for _, fi := range fis {
+			if info, err := os.Stat(fi.Path); err == nil {
+				stat.Bytes += info.Size()
+			}
+			stat.Types[fi.Type()]++
+			if fi.Tm.After(stat.Latest) {
+				stat.Latest = fi.Tm
+			}
+		}
+		if stat.DiskBytes, err = dirSize(userPath(name)); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+func validUserName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid user name [%s]", name)
+	}
+	return nil
+}
+
+// jobs of user 'name' or of its FileItems 'fis'
+func userJobs(name string, fis []*fdb.FileItem) func(job *fdb.Job) bool {
+	ids := make(map[string]struct{}, len(fis))
+	for _, fi := range fis {
+		ids[fi.Id] = struct{}{}
+	}
+	return func(job *fdb.Job) bool {
+		_, ok := ids[job.FiId]
+		return ok || job.User == name
+	}
+}
+
+// RenameUser moves user space directory, rewrites path of every FileItem as a whole & owner of its jobs.
+// UserSpace of 'oldName' already in use is stale after renaming.
+func RenameUser(oldName, newName string) error {
+	for _, name := range []string{oldName, newName} {
+		if err := validUserName(name); err != nil {
+			return err
+		}
+	}
+	oldPath, newPath := userPath(oldName), userPath(newName)
+	if !fd.DirExists(oldPath) {
+		return fmt.Errorf("user [%s] does NOT exist", oldName)
+	}
+	if fd.DirExists(newPath) {
+		return fmt.Errorf("user [%s] already exists", newName)
+	}
+
+	olds, err := userFileItems(oldName)
+	if err != nil {
+		return err
+	}
+	news := make([]*fdb.FileItem, 0, len(olds))
+	for _, fi := range olds {
+		nfi := *fi
+		nfi.Path = newPath + strings.TrimPrefix(fi.Path, oldPath)
+		news = append(news, &nfi)
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	if err := fdb.ReplaceFileItems(olds, news); err != nil {
+		if errBack := os.Rename(newPath, oldPath); errBack != nil {
+			return fmt.Errorf("%w, and rolling back directory failed: %v", err, errBack)
+		}
+		return err
+	}
+	if _, err := fdb.EditJobs(userJobs(oldName, olds), func(job *fdb.Job) { job.User = newName }); err != nil {
+		return fmt.Errorf("user [%s] is renamed, but its jobs are NOT: %w", oldName, err)
+	}
+	return nil
+}
+
+// EraseUser removes every FileItem of user from DB as a whole & its jobs, then removes user space directory.
+// UserSpace of 'name' already in use MUST NOT be used after erasing.
+func EraseUser(name string) error {
+	if err := validUserName(name); err != nil {
+		return err
+	}
+	fis, err := userFileItems(name)
+	if err != nil {
+		return err
+	}
+	if err := fdb.DeleteFileItems(fis); err != nil {
+		return err
+	}
+	if _, err := fdb.DeleteJobs(userJobs(name, fis)); err != nil {
+		return err
+	}
+	return os.RemoveAll(userPath(name))
+}
@@ -0,0 +1,63 @@
+package filemgr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	fd "github.com/digisan/gotk/file-dir"
+	lk "github.com/digisan/logkit"
+)
+
+func TestUserLifecycle(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	name := fmt.Sprintf("lifecycle %d", time.Now().UnixNano())
+	us, err := UseUser(name)
+	lk.FailOnErr("%v", err)
+	for i := 0; i < 3; i++ {
+		_, err := us.SaveFile(strings.NewReader(fmt.Sprint("lifecycle ", i)), fmt.Sprintf("f%d.txt", i), "", nil, true, "G0")
+		lk.FailOnErr("%v", err)
+	}
+
+	stats, err := ListUserStats()
+	lk.FailOnErr("%v", err)
+	for _, stat := range stats {
+		fmt.Printf("%+v\n", *stat)
+	}
+
+	_, err = us.QueueThumbnails(us.FIs[0].Id)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(RenameUser("..", name) == nil, "%v", fmt.Errorf("invalid old name accepted"))
+
+	// rename, jobs follow
+	newName := name + " renamed"
+	lk.FailOnErr("%v", RenameUser(name, newName))
+	lk.FailOnErrWhen(fd.DirExists(us.UserPath), "%v", fmt.Errorf("old user path still exists"))
+	lk.FailOnErrWhen(RenameUser(name, newName) == nil, "%v", fmt.Errorf("renamed missing user"))
+
+	us2, err := UseUser(newName)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(us2.FIs) != 3, "%v", fmt.Errorf("renamed user has %d items", len(us2.FIs)))
+	for _, fi := range us2.FIs {
+		lk.FailOnErrWhen(!fd.FileExists(fi.Path), "%v", fmt.Errorf("%s is missing", fi.Path))
+	}
+
+	jobs, err := fdb.ListJobs(func(job *fdb.Job) bool { return job.FiId == us.FIs[0].Id })
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(jobs) != 1 || jobs[0].User != newName, "%v", fmt.Errorf("jobs NOT renamed: %v", jobs))
+
+	// erase, jobs are dropped
+	lk.FailOnErr("%v", EraseUser(newName))
+	jobs, err = fdb.ListJobs(func(job *fdb.Job) bool { return job.User == newName || job.User == name })
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(jobs) != 0, "%v", fmt.Errorf("jobs of erased user are left: %v", jobs))
+	lk.FailOnErrWhen(fd.DirExists(us2.UserPath), "%v", fmt.Errorf("erased user path still exists"))
+	for _, fi := range us2.FIs {
+		lk.FailOnErrWhen(fdb.IsExisting(fi.ID()), "%v", fmt.Errorf("%s is still in DB", fi.ID()))
+	}
+	lk.FailOnErrWhen(EraseUser("../x") == nil, "%v", fmt.Errorf("invalid name accepted"))
+}
@@ -0,0 +1,245 @@
+package filemgr
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+	fd "github.com/digisan/gotk/file-dir"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	MarkTopLeft     = "top-left"
+	MarkTopRight    = "top-right"
+	MarkBottomLeft  = "bottom-left"
+	MarkBottomRight = "bottom-right"
+	MarkCenter      = "center"
+	MarkTile        = "tile" // repeated over whole image or frame
+)
+
+// Watermark is a text or image overlay drawn on images & video frames
+type Watermark struct {
+	Text     string  `json:"text,omitempty"`     // text mark in white with dark outline, used if 'Image' is empty
+	Image    string  `json:"image,omitempty"`    // id of PNG or JPEG FileItem in same user space, or file name in 'OptMarkDir'. its transparency is kept
+	Position string  `json:"position,omitempty"` // MarkBottomRight (default), MarkTopLeft, MarkTopRight, MarkBottomLeft, MarkCenter, MarkTile
+	Opacity  float64 `json:"opacity,omitempty"`  // 0-1, 0 for 0.5
+	Size     float64 `json:"size,omitempty"`     // mark width as fraction of target width, 0 for 0.25
+	Margin   float64 `json:"margin,omitempty"`   // gap to edges as fraction of target shorter edge, 0 for 0.03
+	path     string  // file of 'Image', set by 'resolve'
+}
+
+func (wm *Watermark) validate() error {
+	if wm.Text == "" && wm.Image == "" {
+		return fmt.Errorf("watermark needs text or image")
+	}
+	if p := wm.Position; p != "" && NotIn(p, MarkTopLeft, MarkTopRight, MarkBottomLeft, MarkBottomRight, MarkCenter, MarkTile) {
+		return fmt.Errorf("watermark position [%s] is unsupported", p)
+	}
+	if wm.Opacity < 0 || wm.Opacity > 1 {
+		return fmt.Errorf("watermark opacity [%v] is out of 0-1", wm.Opacity)
+	}
+	if wm.Size < 0 || wm.Size > 1 || wm.Margin < 0 || wm.Margin >= 0.5 {
+		return fmt.Errorf("watermark size [%v] or margin [%v] is out of range", wm.Size, wm.Margin)
+	}
+	return nil
+}
+
+// OptMarkDir sets directory of watermark images shared by all users, 'Watermark.Image' can be file name in it
+func OptMarkDir(dir string) {
+	opt.markDir = dir
+}
+
+// copy of 'wm' whose 'Image' is resolved to an image FileItem of 'us', or a file in 'OptMarkDir'.
+// Other paths on server are NOT accepted
+func (wm *Watermark) resolve(us *UserSpace) (*Watermark, error) {
+	if wm == nil || wm.Image == "" {
+		return wm, nil
+	}
+	v := *wm
+	if len(wm.Image) >= 32 {
+		fi, ok, err := fdb.FirstFileItem(strings.ToLower(wm.Image))
+		if err != nil {
+			return nil, err
+		}
+		if ok && us.Own(fi) && fi.Type() == fd.Image {
+			v.path = fi.Path
+			return &v, nil
+		}
+	}
+	if name := filepath.Base(wm.Image); opt.markDir != "" && name == wm.Image && NotIn(name, ".", "..", PS) {
+		if p := filepath.Join(opt.markDir, name); fd.FileExists(p) {
+			v.path = p
+			return &v, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: watermark image [%s] is neither an image of [%s] nor in mark directory", ErrInvalid, wm.Image, us.UName)
+}
+
+func (wm *Watermark) opacity() float64 {
+	if wm.Opacity == 0 {
+		return 0.5
+	}
+	return wm.Opacity
+}
+
+// draw 'text' in white with dark outline, its width is about 'width' pixels
+func textMark(text string, width int) (*image.RGBA, error) {
+	ft, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	face := func(size float64) (font.Face, error) {
+		return opentype.NewFace(ft, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	}
+	// measure at 100 points, then scale to 'width'
+	f, err := face(100)
+	if err != nil {
+		return nil, err
+	}
+	w100 := font.MeasureString(f, text).Ceil()
+	f.Close()
+	if w100 == 0 {
+		return nil, fmt.Errorf("watermark text is blank")
+	}
+	if f, err = face(max(8, 100*float64(width)/float64(w100))); err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := f.Metrics()
+	pad := max(1, m.Height.Ceil()/16) // outline width
+	w := font.MeasureString(f, text).Ceil() + 2*pad
+	h := (m.Ascent + m.Descent).Ceil() + 2*pad
+	mark := image.NewRGBA(image.Rect(0, 0, w, h))
+	d := &font.Drawer{Dst: mark, Face: f}
+	base := fixed.P(pad, pad+m.Ascent.Ceil())
+
+	d.Src = image.NewUniform(color.RGBA{0, 0, 0, 160})
+	for _, off := range []image.Point{{-pad, 0}, {pad, 0}, {0, -pad}, {0, pad}} {
+		d.Dot = base.Add(fixed.P(off.X, off.Y))
+		d.DrawString(text)
+	}
+	d.Src, d.Dot = image.White, base
+	d.DrawString(text)
+	return mark, nil
+}
+
+// overlay of 'wm' for target of 'w' x 'h', opacity applied
+func (wm *Watermark) render(w, h int) (*image.RGBA, error) {
+	size := wm.Size
+	if size == 0 {
+		size = 0.25
+	}
+	width := max(1, int(math.Round(float64(w)*size)))
+
+	var mark *image.RGBA
+	if wm.Image != "" {
+		if wm.path == "" {
+			return nil, fmt.Errorf("watermark image [%s] is NOT resolved", wm.Image)
+		}
+		img, err := loadImage(wm.path)
+		if err != nil {
+			return nil, err
+		}
+		b := img.Bounds()
+		mark = resizeImage(img, width, max(1, b.Dy()*width/b.Dx()))
+	} else {
+		var err error
+		if mark, err = textMark(wm.Text, width); err != nil {
+			return nil, err
+		}
+	}
+	if mark.Bounds().Dx() > w || mark.Bounds().Dy() > h { // never larger than target
+		b := mark.Bounds()
+		scale := min(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+		mark = resizeImage(mark, max(1, int(float64(b.Dx())*scale)), max(1, int(float64(b.Dy())*scale)))
+	}
+
+	a := wm.opacity()
+	for i := range mark.Pix { // premultiplied, so all channels fade
+		mark.Pix[i] = uint8(math.Round(float64(mark.Pix[i]) * a))
+	}
+	return mark, nil
+}
+
+// top-left points of marks of 'mw' x 'mh' on target of 'w' x 'h'
+func (wm *Watermark) places(w, h, mw, mh int) []image.Point {
+	margin := wm.Margin
+	if margin == 0 {
+		margin = 0.03
+	}
+	gap := int(float64(min(w, h)) * margin)
+	left, top := min(gap, w-mw), min(gap, h-mh)
+	right, bottom := max(0, w-mw-gap), max(0, h-mh-gap)
+	switch wm.Position {
+	case MarkTopLeft:
+		return []image.Point{{left, top}}
+	case MarkTopRight:
+		return []image.Point{{right, top}}
+	case MarkBottomLeft:
+		return []image.Point{{left, bottom}}
+	case MarkCenter:
+		return []image.Point{{(w - mw) / 2, (h - mh) / 2}}
+	case MarkTile:
+		pts := []image.Point{}
+		for y, row := gap, 0; y < h; y, row = y+mh+2*gap, row+1 {
+			for x := gap - (row%2)*(mw+2*gap)/2; x < w; x += mw + 2*gap {
+				pts = append(pts, image.Point{x, y})
+			}
+		}
+		return pts
+	}
+	return []image.Point{{right, bottom}}
+}
+
+// draw 'wm' over 'img'
+func (wm *Watermark) apply(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	mark, err := wm.render(b.Dx(), b.Dy())
+	if err != nil {
+		return nil, err
+	}
+	dst := roi4rgba(img, b.Min.X, b.Min.Y, b.Max.X, b.Max.Y)
+	for _, pt := range wm.places(b.Dx(), b.Dy(), mark.Bounds().Dx(), mark.Bounds().Dy()) {
+		draw.Draw(dst, mark.Bounds().Add(pt), mark, image.Point{}, draw.Over)
+	}
+	return dst, nil
+}
+
+// render overlay of 'wm' for video frames of 'w' x 'h' as full frame PNG file, caller removes it
+func (wm *Watermark) frameOverlay(w, h int) (string, error) {
+	frame, err := wm.apply(image.NewRGBA(image.Rect(0, 0, w, h)))
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp("", "filemgr-mark-*.png")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	if _, err = savePNG(frame, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// draw 'wm' over every frame of video 'in' into 'out', whose extension selects mp4 or webm encoding
+func watermarkVideo(in, out string, wm *Watermark, progress func(pct float64)) error {
+	r := Rendition{Format: FmtMP4, Watermark: wm}
+	if strings.EqualFold(filepath.Ext(out), "."+FmtWebM) {
+		r.Format = FmtWebM
+	}
+	return transcode(in, out, r, progress)
+}
@@ -0,0 +1,124 @@
+package filemgr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lk "github.com/digisan/logkit"
+)
+
+func testFlat(w, h int, c color.RGBA) *bytes.Buffer {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	buf := &bytes.Buffer{}
+	lk.FailOnErr("%v", png.Encode(buf, img))
+	return buf
+}
+
+// count of pixels in 'rect' differing from 'c'
+func marked(img image.Image, rect image.Rectangle, c color.RGBA) int {
+	n := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if color.RGBAModel.Convert(img.At(x, y)) != c {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestWatermark(t *testing.T) {
+
+	InitFileMgr("./data")
+	OptThumbOnSave(false)
+	defer OptThumbOnSave(true)
+
+	us, err := UseUser("watermark test")
+	lk.FailOnErr("%v", err)
+
+	gray := color.RGBA{60, 60, 60, 255}
+
+	// permanent text mark at saving
+	po := &ProcessOptions{Watermark: &Watermark{Text: "CONFIDENTIAL", Opacity: 1}}
+	path, err := us.SaveFile(testFlat(400, 200, gray), "shot.png", "", po, false, "marks")
+	lk.FailOnErr("%v", err)
+	img, err := loadImage(path)
+	lk.FailOnErr("%v", err)
+	br, tl := marked(img, image.Rect(200, 100, 400, 200), gray), marked(img, image.Rect(0, 0, 200, 100), gray)
+	fmt.Println("bottom-right:", br, "top-left:", tl)
+	lk.FailOnErrWhen(br == 0 || tl != 0, "%v", fmt.Errorf("text mark MUST be only at bottom-right, %d %d", br, tl))
+
+	_, err = us.SaveFile(testFlat(40, 20, gray), "bad.png", "", &ProcessOptions{Watermark: &Watermark{}}, false, "marks")
+	lk.FailOnErrWhen(err == nil, "%v", fmt.Errorf("empty watermark MUST fail"))
+
+	// image mark of FileItem on derived rendition, source is kept clean
+	_, err = us.SaveFile(testFlat(50, 50, color.RGBA{255, 0, 0, 255}), "logo.png", "", nil, false, "marks")
+	lk.FailOnErr("%v", err)
+	logo := us.FIs[len(us.FIs)-1]
+	_, err = us.SaveFile(testFlat(200, 200, gray), "plain.png", "", nil, false, "marks")
+	lk.FailOnErr("%v", err)
+	src := us.FIs[len(us.FIs)-1]
+
+	tr := &ImageTransform{Watermark: &Watermark{Image: logo.Id, Position: MarkTopLeft, Size: 0.25, Opacity: 0.5}}
+	dfi, err := us.TransformImage(src.Id, tr, true)
+	lk.FailOnErr("%v", err)
+	img, err = loadImage(dfi.Path)
+	lk.FailOnErr("%v", err)
+	c := color.RGBAModel.Convert(img.At(20, 20)).(color.RGBA)
+	fmt.Println("mark pixel:", c)
+	lk.FailOnErrWhen(c.R < 140 || c.R > 170 || c.G > 40, "%v", fmt.Errorf("half transparent red expected, got %v", c))
+	lk.FailOnErrWhen(marked(img, image.Rect(100, 100, 200, 200), gray) != 0, "%v", fmt.Errorf("mark MUST be at top-left"))
+	img, err = loadImage(src.Path)
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(marked(img, img.Bounds(), gray) != 0, "%v", fmt.Errorf("source MUST be unchanged"))
+
+	// image mark only from user space or mark directory, NOT any path on server
+	dir := t.TempDir()
+	lk.FailOnErr("%v", os.WriteFile(filepath.Join(dir, "asset.png"), testFlat(50, 50, color.RGBA{0, 0, 255, 255}).Bytes(), os.ModePerm))
+	for _, name := range []string{filepath.Join(dir, "asset.png"), "asset.png", "../" + filepath.Base(dir) + "/asset.png"} {
+		_, err = us.TransformImage(src.Id, &ImageTransform{Watermark: &Watermark{Image: name}}, true)
+		lk.FailOnErrWhen(!errors.Is(err, ErrInvalid), "%v", fmt.Errorf("[%s] MUST be rejected, got %v", name, err))
+	}
+	other, err := UseUser("watermark test other")
+	lk.FailOnErr("%v", err)
+	_, err = other.SaveFile(testFlat(40, 20, gray), "steal.png", "", &ProcessOptions{Watermark: &Watermark{Image: logo.Id}}, false, "marks")
+	lk.FailOnErrWhen(!errors.Is(err, ErrInvalid), "%v", fmt.Errorf("FileItem of other user MUST be rejected, got %v", err))
+	OptMarkDir(dir)
+	defer OptMarkDir("")
+	_, err = us.TransformImage(src.Id, &ImageTransform{Watermark: &Watermark{Image: "asset.png"}}, true)
+	lk.FailOnErr("%v", err)
+
+	// tiled
+	tiled, err := (&Watermark{Text: "DRAFT", Position: MarkTile, Size: 0.2}).apply(image.NewRGBA(image.Rect(0, 0, 300, 300)))
+	lk.FailOnErr("%v", err)
+	for _, q := range []image.Rectangle{image.Rect(0, 0, 150, 150), image.Rect(150, 150, 300, 300)} {
+		lk.FailOnErrWhen(marked(tiled, q, color.RGBA{}) == 0, "%v", fmt.Errorf("tile MUST cover %v", q))
+	}
+
+	// video overlay
+	fakeFFmpeg(t)
+	mark, err := (&Watermark{Text: "PREVIEW"}).frameOverlay(1280, 720)
+	lk.FailOnErr("%v", err)
+	defer os.Remove(mark)
+	args := Rendition{Format: FmtMP4, Height: 480}.args("in.mp4", "out.mp4", 720, mark)
+	fmt.Println(args)
+	lk.FailOnErrWhen(!strings.Contains(strings.Join(args, " "), "-filter_complex [0:v][1:v]overlay=0:0,scale=-2:480[out]"), "%v",
+		fmt.Errorf("overlay filter expected, got %v", args))
+
+	_, err = us.SaveFile(bytes.NewReader(largeMedia(1<<16)), "clip.mp4", "", nil, false, "marks")
+	lk.FailOnErr("%v", err)
+	rs, err := us.Transcode(us.FIs[len(us.FIs)-1].Id, nil, Rendition{Format: FmtMP4, Height: 480, Watermark: &Watermark{Text: "PREVIEW"}})
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(rs) != 1, "%v", fmt.Errorf("expected 1 marked rendition, got %d", len(rs)))
+}
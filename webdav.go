@@ -0,0 +1,482 @@
+package filemgr
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digisan/file-mgr/fdb"
+	. "github.com/digisan/go-generics"
+	fd "github.com/digisan/gotk/file-dir"
+	"golang.org/x/net/webdav"
+)
+
+// xml namespace of file-mgr dead properties, i.e. 'note' & 'time' in PROPFIND
+const DavNS = "https://github.com/digisan/file-mgr/"
+
+var (
+	davNote = xml.Name{Space: DavNS, Local: "note"}
+	davTime = xml.Name{Space: DavNS, Local: "time"}
+	rYM     = regexp.MustCompile(`^\d{4}-\d{2}$`)
+)
+
+// WebDAV serves user space as collections of "month/group.../type/file", mirroring 'PathContent'.
+// PUT goes through 'SaveFile' into its month, a file is also found by its name without type collection.
+// MOVE changes groups, DELETE removes FileItems.
+func (us *UserSpace) WebDAV(prefix string) http.Handler {
+	return &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: &davFS{us: us},
+		LockSystem: webdav.NewMemLS(),
+	}
+}
+
+// davFS implements webdav.FileSystem. UserSpace is not goroutine safe, all access is serialized.
+type davFS struct {
+	sync.Mutex
+	us *UserSpace
+}
+
+// "/2022-07/group0/image/" => "2022-07/group0/image"
+func davRel(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// on-disk path of dav path
+func (dfs *davFS) diskPath(rel string) string {
+	return filepath.Join(dfs.us.UserPath, filepath.FromSlash(rel))
+}
+
+// dav path of fileItem
+func (dfs *davFS) relOf(fi *fdb.FileItem) string {
+	return filepath.ToSlash(strings.TrimPrefix(fi.Path, dfs.us.UserPath))
+}
+
+// fileItem at stored path 'rel', or named 'rel' in its group collection under any type or none, as type is sniffed
+// from content, e.g. "2022-07/group0/moon.png" & "2022-07/group0/text/moon.png" are the latest "moon.png" saved at
+// "2022-07/group0/image/".
+func (dfs *davFS) fileItem(rel string) *fdb.FileItem {
+	var named *fdb.FileItem
+	dir, name := path.Dir(rel), path.Base(rel)
+	if fd.IsSupportedFileType(path.Base(dir)) {
+		dir = path.Dir(dir)
+	}
+	for _, fi := range dfs.us.FIs {
+		r := dfs.relOf(fi)
+		if r == rel {
+			return fi
+		}
+		if path.Dir(path.Dir(r)) == dir && In(name, fi.Name(), fi.OriName) {
+			if named == nil || !fi.Tm.Before(named.Tm) {
+				named = fi
+			}
+		}
+	}
+	return named
+}
+
+// all fileItems at 'rel' or under 'rel' collection
+func (dfs *davFS) fileItemsUnder(rel string) (fis []*fdb.FileItem) {
+	for _, fi := range dfs.us.FIs {
+		if r := dfs.relOf(fi); rel == "" || r == rel || strings.HasPrefix(r, rel+"/") {
+			fis = append(fis, fi)
+		}
+	}
+	return
+}
+
+func (dfs *davFS) isDir(rel string) bool {
+	if rel == "" || fd.DirExists(dfs.diskPath(rel)) {
+		return true
+	}
+	for _, fi := range dfs.us.FIs {
+		if strings.HasPrefix(dfs.relOf(fi), rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (dfs *davFS) stat(rel string) (os.FileInfo, error) {
+	if fi := dfs.fileItem(rel); fi != nil {
+		info, err := os.Stat(fi.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &fsInfo{name: path.Base(rel), size: info.Size(), tm: fi.Tm}, nil
+	}
+	if dfs.isDir(rel) {
+		return &fsInfo{name: path.Base("/" + rel), tm: dfs.modTime(rel), dir: true}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// collection time is the latest FileItem time under it, or its directory time if it's empty
+func (dfs *davFS) modTime(rel string) (tm time.Time) {
+	for _, fi := range dfs.fileItemsUnder(rel) {
+		if fi.Tm.After(tm) {
+			tm = fi.Tm
+		}
+	}
+	if tm.IsZero() {
+		if info, err := os.Stat(dfs.diskPath(rel)); err == nil {
+			tm = info.ModTime()
+		}
+	}
+	return tm
+}
+
+// "2022-07/group0/group1/image/moon.png" => ym: 2022-07, groups: [group0 group1], name: moon.png
+func davTarget(rel string) (ym string, groups []string, name string) {
+	segs := strings.Split(rel, "/")
+	name, segs = segs[len(segs)-1], segs[:len(segs)-1]
+	if len(segs) > 0 && rYM.MatchString(segs[0]) {
+		ym, segs = segs[0], segs[1:]
+	}
+	if len(segs) > 0 && fd.IsSupportedFileType(segs[len(segs)-1]) {
+		segs = segs[:len(segs)-1]
+	}
+	return ym, segs, name
+}
+
+// FileItem time of a file PUT into month 'ym', now if it's this month, otherwise same clock on the 1st of 'ym'
+func putTime(ym string, now time.Time) (time.Time, error) {
+	if ym == "" || ym == now.Format("2006-01") {
+		return now, nil
+	}
+	month, err := time.ParseInLocation("2006-01", ym, now.Location())
+	if err != nil {
+		return now, fmt.Errorf("%w: invalid month [%s]", os.ErrInvalid, ym)
+	}
+	return month.Add(now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))), nil
+}
+
+func (dfs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	dfs.Lock()
+	defer dfs.Unlock()
+
+	return dfs.stat(davRel(name))
+}
+
+func (dfs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	dfs.Lock()
+	defer dfs.Unlock()
+
+	rel := davRel(name)
+	if rel == "" || dfs.fileItem(rel) != nil || dfs.isDir(rel) {
+		return os.ErrExist
+	}
+	if !dfs.isDir(path.Dir(rel)) && path.Dir(rel) != "." {
+		return os.ErrNotExist
+	}
+	return os.Mkdir(dfs.diskPath(rel), perm)
+}
+
+func (dfs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	dfs.Lock()
+	defer dfs.Unlock()
+
+	// content can only be replaced as a whole, i.e. PUT or COPY
+	rel := davRel(name)
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		if rel == "" || dfs.isDir(rel) {
+			return nil, os.ErrInvalid
+		}
+		if dfs.fileItem(rel) == nil && flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		if ym, _, _ := davTarget(rel); ym != "" {
+			if _, err := putTime(ym, time.Now()); err != nil {
+				return nil, err
+			}
+		}
+		tmp, err := os.CreateTemp("", "filemgr-dav-*")
+		if err != nil {
+			return nil, err
+		}
+		return &davUpload{File: tmp, dfs: dfs, rel: rel}, nil
+	}
+
+	if fi := dfs.fileItem(rel); fi != nil {
+		f, err := os.Open(fi.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &davFile{File: f, dfs: dfs, fi: fi}, nil
+	}
+	if dfs.isDir(rel) {
+		return &davDir{dfs: dfs, rel: rel}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (dfs *davFS) RemoveAll(ctx context.Context, name string) error {
+	dfs.Lock()
+	defer dfs.Unlock()
+
+	rel := davRel(name)
+	if rel == "" {
+		return os.ErrPermission
+	}
+	fis := dfs.fileItemsUnder(rel)
+	if fi := dfs.fileItem(rel); len(fis) == 0 && fi != nil {
+		fis = append(fis, fi)
+	}
+	for _, fi := range fis {
+		if err := dfs.us.DelFileItem(fi.ID()); err != nil {
+			return err
+		}
+	}
+	if p := dfs.diskPath(rel); fd.DirExists(p) {
+		return os.RemoveAll(p)
+	}
+	return nil
+}
+
+// only groups can be changed by MOVE, month, type & file name are kept
+func (dfs *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	dfs.Lock()
+	defer dfs.Unlock()
+
+	oldRel, newRel := davRel(oldName), davRel(newName)
+	if oldRel == "" || newRel == "" {
+		return os.ErrPermission
+	}
+	fis := dfs.fileItemsUnder(oldRel)
+	if len(fis) == 0 {
+		// named file keeps its type & stored name
+		if fi := dfs.fileItem(oldRel); fi != nil {
+			if path.Base(newRel) != path.Base(oldRel) {
+				return fmt.Errorf("%w: file name of [%s] cannot be changed", os.ErrPermission, newRel)
+			}
+			dir := path.Dir(newRel)
+			if fd.IsSupportedFileType(path.Base(dir)) {
+				dir = path.Dir(dir)
+			}
+			return dfs.move(fi, path.Join(dir, fi.Type(), fi.StoredName()))
+		}
+		if p := dfs.diskPath(oldRel); fd.DirExists(p) {
+			return os.Rename(p, dfs.diskPath(newRel))
+		}
+		return os.ErrNotExist
+	}
+	for _, fi := range fis {
+		if err := dfs.move(fi, newRel+strings.TrimPrefix(dfs.relOf(fi), oldRel)); err != nil {
+			return err
+		}
+	}
+	if p := dfs.diskPath(oldRel); fd.DirExists(p) {
+		return dfs.us.SelfCheck(true)
+	}
+	return nil
+}
+
+func (dfs *davFS) move(fi *fdb.FileItem, newRel string) error {
+	oldRel := dfs.relOf(fi)
+	oldYM, oldGrps, oldName := davTarget(oldRel)
+	newYM, newGrps, newName := davTarget(newRel)
+	switch {
+	case oldYM != newYM:
+		return fmt.Errorf("%w: month of [%s] cannot be changed", os.ErrPermission, newRel)
+	case oldName != newName:
+		return fmt.Errorf("%w: file name of [%s] cannot be changed", os.ErrPermission, newRel)
+	case path.Base(path.Dir(newRel)) != fi.Type():
+		return fmt.Errorf("%w: file type of [%s] cannot be changed", os.ErrPermission, newRel)
+	case len(newGrps) < len(oldGrps):
+		return fmt.Errorf("%w: group of [%s] cannot be removed", os.ErrPermission, newRel)
+	}
+	for i, grp := range newGrps {
+		if i < len(oldGrps) && oldGrps[i] == grp {
+			continue
+		}
+		if err := dfs.us.SetFIGroup(fi.ID(), i, grp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+
+// davFile is a readable FileItem, holding 'note' & 'time' as dead properties
+type davFile struct {
+	*os.File
+	dfs *davFS
+	fi  *fdb.FileItem
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *davFile) Stat() (fs.FileInfo, error) {
+	f.dfs.Lock()
+	defer f.dfs.Unlock()
+
+	return f.dfs.stat(f.dfs.relOf(f.fi))
+}
+
+func (f *davFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	f.dfs.Lock()
+	defer f.dfs.Unlock()
+
+	note := &strings.Builder{}
+	if err := xml.EscapeText(note, []byte(f.fi.Note)); err != nil {
+		return nil, err
+	}
+	return map[xml.Name]webdav.Property{
+		davNote: {XMLName: davNote, InnerXML: []byte(note.String())},
+		davTime: {XMLName: davTime, InnerXML: []byte(f.fi.Tm.Format(time.RFC3339))},
+	}, nil
+}
+
+// only 'note' is writable
+func (f *davFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	f.dfs.Lock()
+	defer f.dfs.Unlock()
+
+	note, set := f.fi.Note, false
+	pstat := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			if prop.XMLName != davNote {
+				pstat.Status = http.StatusForbidden
+			}
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: prop.XMLName})
+			if prop.XMLName == davNote {
+				note, set = "", true
+				if !patch.Remove {
+					var v struct {
+						Text string `xml:",chardata"`
+					}
+					if err := xml.Unmarshal([]byte("<v>"+string(prop.InnerXML)+"</v>"), &v); err != nil {
+						return nil, err
+					}
+					note = v.Text
+				}
+			}
+		}
+	}
+	if set && pstat.Status == http.StatusOK {
+		if err := f.dfs.us.SetFINote(f.fi.ID(), note); err != nil {
+			return nil, err
+		}
+	}
+	return []webdav.Propstat{pstat}, nil
+}
+
+// davDir is a collection of month, group or type
+type davDir struct {
+	dfs *davFS
+	rel string
+	pos int
+}
+
+func (d *davDir) Close() error                                 { return nil }
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, os.ErrInvalid }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+
+func (d *davDir) Stat() (fs.FileInfo, error) {
+	d.dfs.Lock()
+	defer d.dfs.Unlock()
+
+	return d.dfs.stat(d.rel)
+}
+
+func (d *davDir) Readdir(count int) ([]fs.FileInfo, error) {
+	d.dfs.Lock()
+	defer d.dfs.Unlock()
+
+	names := d.dfs.us.PathContent(d.rel)
+	if entries, err := os.ReadDir(d.dfs.diskPath(d.rel)); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+
+	infos := []fs.FileInfo{}
+	for _, name := range Settify(names...) {
+		info, err := d.dfs.stat(path.Join(d.rel, name))
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	if d.pos >= len(infos) && count > 0 {
+		return nil, io.EOF
+	}
+	infos = infos[d.pos:]
+	if count > 0 && count < len(infos) {
+		infos = infos[:count]
+	}
+	d.pos += len(infos)
+	return infos, nil
+}
+
+// davUpload buffers PUT body, then saves it via 'SaveFile' on Close
+type davUpload struct {
+	*os.File
+	dfs *davFS
+	rel string
+}
+
+func (u *davUpload) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (u *davUpload) Stat() (fs.FileInfo, error) {
+	info, err := u.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &fsInfo{name: path.Base(u.rel), size: info.Size(), tm: info.ModTime()}, nil
+}
+
+func (u *davUpload) Close() error {
+	defer os.Remove(u.File.Name())
+
+	if _, err := u.File.Seek(0, io.SeekStart); err != nil {
+		u.File.Close()
+		return err
+	}
+	defer u.File.Close()
+
+	u.dfs.Lock()
+	defer u.dfs.Unlock()
+
+	var note string
+	old := u.dfs.fileItem(u.rel)
+	if old != nil {
+		note = old.Note
+	}
+	ym, groups, name := davTarget(u.rel)
+	now, err := putTime(ym, time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := u.dfs.us.saveFile(u.File, name, note, nil, now, ym != "", groups...); err != nil {
+		return err
+	}
+	if old != nil {
+		return u.dfs.us.DelFileItem(old.ID())
+	}
+	return nil
+}
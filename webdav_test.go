@@ -0,0 +1,142 @@
+package filemgr
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	lk "github.com/digisan/logkit"
+)
+
+func davDo(method, url, body string, hdr map[string]string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	lk.FailOnErr("%v", err)
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	lk.FailOnErr("%v", err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	lk.FailOnErr("%v", err)
+	return resp.StatusCode, string(data)
+}
+
+func TestWebDAV(t *testing.T) {
+
+	InitFileMgr("./data")
+
+	us, err := UseUser("dav test")
+	lk.FailOnErr("%v", err)
+
+	srv := httptest.NewServer(us.WebDAV("/dav"))
+	defer srv.Close()
+
+	ym := time.Now().Format("2006-01")
+
+	// PUT => SaveFile
+	code, _ := davDo("PUT", srv.URL+"/dav/"+ym+"/G0/G1/hello.txt", "hello webdav", nil)
+	lk.FailOnErrWhen(code != http.StatusCreated, "%v", fmt.Errorf("PUT: %d", code))
+
+	fi := us.FIs[len(us.FIs)-1]
+	rel := filepath.ToSlash(strings.TrimPrefix(fi.Path, us.UserPath))
+	fmt.Println("---saved:", rel)
+	lk.FailOnErrWhen(fi.GroupList != "G0^G1", "%v", fmt.Errorf("groups: %s", fi.GroupList))
+
+	// read back at PUT URL
+	code, body := davDo("GET", srv.URL+"/dav/"+ym+"/G0/G1/hello.txt", "", nil)
+	lk.FailOnErrWhen(code != http.StatusOK || body != "hello webdav", "%v", fmt.Errorf("GET: %d %s", code, body))
+
+	// PUT again at same URL replaces it
+	code, _ = davDo("PUT", srv.URL+"/dav/"+ym+"/G0/G1/hello.txt", "hello again", nil)
+	lk.FailOnErrWhen(code != http.StatusCreated, "%v", fmt.Errorf("PUT: %d", code))
+	code, body = davDo("GET", srv.URL+"/dav/"+ym+"/G0/G1/hello.txt", "", nil)
+	lk.FailOnErrWhen(code != http.StatusOK || body != "hello again", "%v", fmt.Errorf("GET: %d %s", code, body))
+	code, _ = davDo("GET", srv.URL+"/dav/"+rel, "", nil)
+	lk.FailOnErrWhen(code != http.StatusNotFound, "%v", fmt.Errorf("GET replaced: %d", code))
+	fi = us.FIs[len(us.FIs)-1]
+	rel = filepath.ToSlash(strings.TrimPrefix(fi.Path, us.UserPath))
+
+	// PUT into a type collection other than sniffed one is found there as well
+	code, _ = davDo("PUT", srv.URL+"/dav/"+ym+"/G0/image/fake.png", "not an image", nil)
+	lk.FailOnErrWhen(code != http.StatusCreated, "%v", fmt.Errorf("PUT: %d", code))
+	fake := us.FIs[len(us.FIs)-1]
+	lk.FailOnErrWhen(fake.Type() == "image", "%v", fmt.Errorf("type MUST be sniffed: %s", fake.Path))
+	code, _ = davDo("PUT", srv.URL+"/dav/"+ym+"/G0/image/fake.png", "still not an image", nil)
+	lk.FailOnErrWhen(code != http.StatusCreated, "%v", fmt.Errorf("PUT: %d", code))
+	code, body = davDo("GET", srv.URL+"/dav/"+ym+"/G0/image/fake.png", "", nil)
+	lk.FailOnErrWhen(code != http.StatusOK || body != "still not an image", "%v", fmt.Errorf("GET: %d %s", code, body))
+	n := 0
+	for _, fi := range us.FIs {
+		if fi.Name() == "fake.png" {
+			n++
+		}
+	}
+	lk.FailOnErrWhen(n != 1, "%v", fmt.Errorf("PUT again MUST replace, %d items", n))
+	fake = us.FIs[len(us.FIs)-1]
+	code, body = davDo("MOVE", srv.URL+"/dav/"+ym+"/G0/image/fake.png", "", map[string]string{"Destination": srv.URL + "/dav/" + ym + "/G0/G8/image/fake.png"})
+	lk.FailOnErrWhen(code != http.StatusCreated, "%v", fmt.Errorf("MOVE: %d %s", code, body))
+	lk.FailOnErrWhen(fake.GroupList != "G0^G8", "%v", fmt.Errorf("groups: %s", fake.GroupList))
+	code, _ = davDo("DELETE", srv.URL+"/dav/"+ym+"/G0/G8/image/fake.png", "", nil)
+	lk.FailOnErrWhen(code != http.StatusNoContent, "%v", fmt.Errorf("DELETE: %d", code))
+
+	// PUT into a past month is saved in that month, invalid month is refused
+	code, _ = davDo("PUT", srv.URL+"/dav/2020-02/G0/old.txt", "old", nil)
+	lk.FailOnErrWhen(code != http.StatusCreated, "%v", fmt.Errorf("PUT: %d", code))
+	old := us.FIs[len(us.FIs)-1]
+	lk.FailOnErrWhen(old.Tm.Format("2006-01") != "2020-02" || !strings.Contains(old.Path, "2020-02"), "%v", fmt.Errorf("month: %v %s", old.Tm, old.Path))
+	code, body = davDo("GET", srv.URL+"/dav/2020-02/G0/old.txt", "", nil)
+	lk.FailOnErrWhen(code != http.StatusOK || body != "old", "%v", fmt.Errorf("GET: %d %s", code, body))
+	code, _ = davDo("PUT", srv.URL+"/dav/2020-13/G0/bad.txt", "bad", nil)
+	lk.FailOnErrWhen(code == http.StatusCreated, "%v", fmt.Errorf("PUT invalid month: %d", code))
+	code, _ = davDo("DELETE", srv.URL+"/dav/2020-02", "", nil)
+	lk.FailOnErrWhen(code != http.StatusNoContent, "%v", fmt.Errorf("DELETE: %d", code))
+
+	// PROPFIND collections, with their modtime
+	code, body = davDo("PROPFIND", srv.URL+"/dav/"+ym+"/G0/", "", map[string]string{"Depth": "1"})
+	fmt.Println(code, body)
+	lk.FailOnErrWhen(code != http.StatusMultiStatus || !strings.Contains(body, "/G0/G1/"), "%v", fmt.Errorf("PROPFIND: %d", code))
+	lk.FailOnErrWhen(!strings.Contains(body, fi.Tm.UTC().Format(http.TimeFormat)), "%v", fmt.Errorf("PROPFIND modtime: %s", body))
+
+	// PROPPATCH & PROPFIND 'note' and 'time'
+	patch := `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:F="` + DavNS + `"><D:set><D:prop><F:note>set via dav</F:note></D:prop></D:set></D:propertyupdate>`
+	code, body = davDo("PROPPATCH", srv.URL+"/dav/"+rel, patch, nil)
+	lk.FailOnErrWhen(code != http.StatusMultiStatus, "%v", fmt.Errorf("PROPPATCH: %d %s", code, body))
+	lk.FailOnErrWhen(fi.Note != "set via dav", "%v", fmt.Errorf("note: %s", fi.Note))
+
+	find := `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:F="` + DavNS + `"><D:prop><F:note/><F:time/><D:getcontentlength/></D:prop></D:propfind>`
+	code, body = davDo("PROPFIND", srv.URL+"/dav/"+rel, find, map[string]string{"Depth": "0"})
+	fmt.Println(code, body)
+	lk.FailOnErrWhen(!strings.Contains(body, "set via dav") || !strings.Contains(body, fi.Tm.Format(time.RFC3339)), "%v", fmt.Errorf("PROPFIND: %s", body))
+
+	// GET
+	code, body = davDo("GET", srv.URL+"/dav/"+rel, "", nil)
+	lk.FailOnErrWhen(code != http.StatusOK || body != "hello again", "%v", fmt.Errorf("GET: %d %s", code, body))
+
+	// MOVE => group change
+	dst := strings.Replace(rel, "/G1/", "/G9/", 1)
+	code, body = davDo("MOVE", srv.URL+"/dav/"+rel, "", map[string]string{"Destination": srv.URL + "/dav/" + dst})
+	lk.FailOnErrWhen(code != http.StatusCreated, "%v", fmt.Errorf("MOVE: %d %s", code, body))
+	lk.FailOnErrWhen(fi.GroupList != "G0^G9", "%v", fmt.Errorf("groups: %s", fi.GroupList))
+
+	// MOVE with a new file name is refused
+	code, _ = davDo("MOVE", srv.URL+"/dav/"+dst, "", map[string]string{"Destination": srv.URL + "/dav/" + ym + "/G0/G9/unknown/renamed.txt"})
+	lk.FailOnErrWhen(code != http.StatusForbidden, "%v", fmt.Errorf("MOVE: %d", code))
+
+	// DELETE => DelFileItem
+	code, _ = davDo("DELETE", srv.URL+"/dav/"+ym+"/G0", "", nil)
+	lk.FailOnErrWhen(code != http.StatusNoContent, "%v", fmt.Errorf("DELETE: %d", code))
+	fis, err := us.FileItems(fi.ID())
+	lk.FailOnErr("%v", err)
+	lk.FailOnErrWhen(len(fis) != 0, "%v", fmt.Errorf("FileItem still exists"))
+
+	code, _ = davDo("PROPFIND", srv.URL+"/dav/"+dst, "", map[string]string{"Depth": "0"})
+	lk.FailOnErrWhen(code != http.StatusNotFound, "%v", fmt.Errorf("PROPFIND: %d", code))
+}